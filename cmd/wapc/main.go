@@ -27,7 +27,9 @@ var cli struct {
 func main() {
 	ctx := kong.Parse(&cli)
 	// Call the Run() method of the selected parsed command.
-	err := ctx.Run(&commands.Context{})
+	err := ctx.Run(&commands.Context{
+		Version: version,
+	})
 	ctx.FatalIfErrorf(err)
 }
 
@@ -0,0 +1,66 @@
+package commands
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// archiveEntry is a generated file to be written into the output archive.
+type archiveEntry struct {
+	name string
+	path string
+}
+
+// writeArchive writes the entries into a gzipped tarball. Timestamps are
+// fixed so that the same outputs always produce the same archive.
+func writeArchive(filename string, entries []archiveEntry) error {
+	byName := make(map[string]string, len(entries))
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if _, exists := byName[entry.name]; !exists {
+			names = append(names, entry.name)
+		}
+		byName[entry.name] = entry.path
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	for _, name := range names {
+		data, err := os.ReadFile(byName[name])
+		if err != nil {
+			return err
+		}
+		if err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     filepath.ToSlash(name),
+			Mode:     0644,
+			Size:     int64(len(data)),
+			ModTime:  time.Unix(0, 0),
+		}); err != nil {
+			return err
+		}
+		if _, err = tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gzw.Close(); err != nil {
+		return err
+	}
+
+	file, err := stageFile(filename, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = file.commit()
+	return err
+}
@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dependencyRule is a single make rule listing the files an output was
+// generated from.
+type dependencyRule struct {
+	target        string
+	prerequisites []string
+}
+
+// writeDepfile writes rules in the make depfile format, which Ninja also
+// understands.
+func writeDepfile(filename string, rules []dependencyRule) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	var buf strings.Builder
+	for _, rule := range mergeRules(rules) {
+		buf.WriteString(escapeMakePath(depPath(cwd, rule.target)))
+		buf.WriteString(":")
+		for _, prerequisite := range rule.prerequisites {
+			buf.WriteString(" \\\n  ")
+			buf.WriteString(escapeMakePath(depPath(cwd, prerequisite)))
+		}
+		buf.WriteString("\n")
+	}
+
+	file, err := stageFile(filename, []byte(buf.String()))
+	if err != nil {
+		return err
+	}
+	_, err = file.commit()
+	return err
+}
+
+// mergeRules combines rules for the same target, such as an archive holding
+// several outputs, and removes duplicate prerequisites.
+func mergeRules(rules []dependencyRule) []dependencyRule {
+	var merged []dependencyRule
+	index := make(map[string]int, len(rules))
+	seen := make(map[string]map[string]struct{}, len(rules))
+	for _, rule := range rules {
+		i, ok := index[rule.target]
+		if !ok {
+			i = len(merged)
+			index[rule.target] = i
+			seen[rule.target] = map[string]struct{}{}
+			merged = append(merged, dependencyRule{target: rule.target})
+		}
+		for _, prerequisite := range rule.prerequisites {
+			if _, ok := seen[rule.target][prerequisite]; ok {
+				continue
+			}
+			seen[rule.target][prerequisite] = struct{}{}
+			merged[i].prerequisites = append(merged[i].prerequisites, prerequisite)
+		}
+	}
+
+	return merged
+}
+
+// depPath returns path relative to cwd if it is inside cwd and as an
+// absolute path otherwise.
+func depPath(cwd, path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(cwd, abs)
+	if err != nil || isOutside(rel) {
+		return filepath.ToSlash(abs)
+	}
+	return filepath.ToSlash(rel)
+}
+
+var makeEscaper = strings.NewReplacer(
+	" ", `\ `,
+	"#", `\#`,
+	"$", "$$",
+)
+
+func escapeMakePath(path string) string {
+	return makeEscaper.Replace(path)
+}
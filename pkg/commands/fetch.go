@@ -0,0 +1,270 @@
+package commands
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// fetcher reads local files and remote resources. Remote resources are
+// cached under ~/.wapc/cache and revalidated with their ETag.
+type fetcher struct {
+	client http.Client
+	token  string
+	// tokenHost is the only host the token is sent to. See scopeToken.
+	tokenHost string
+	offline   bool
+	cacheDir  string
+}
+
+// cacheEntry is the metadata stored next to a cached response body.
+type cacheEntry struct {
+	URL  string `json:"url"`
+	ETag string `json:"etag,omitempty"`
+}
+
+func newFetcher(homeDir, token string, offline bool) *fetcher {
+	return &fetcher{
+		client: http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				Dial: (&net.Dialer{
+					Timeout: 5 * time.Second,
+				}).Dial,
+				TLSHandshakeTimeout: 5 * time.Second,
+			},
+		},
+		token:    token,
+		offline:  offline,
+		cacheDir: filepath.Join(homeDir, "cache"),
+	}
+}
+
+// scopeToken limits the token to the host of location if it is the first
+// remote location the user named: a remote configuration, or the schema of a
+// local configuration. Schemas named by remote configurations are third-party
+// and never receive the token.
+func (f *fetcher) scopeToken(location string) {
+	if f.tokenHost != "" || !isURL(location) {
+		return
+	}
+	if u, err := url.Parse(location); err == nil {
+		f.tokenHost = u.Host
+	}
+}
+
+func (f *fetcher) readFile(file string) ([]byte, error) {
+	if !isURL(file) {
+		return os.ReadFile(file)
+	}
+
+	key := sha256.Sum256([]byte(file))
+	bodyPath := filepath.Join(f.cacheDir, hex.EncodeToString(key[:]))
+	metaPath := bodyPath + ".json"
+
+	var cached cacheEntry
+	cachedBody, err := os.ReadFile(bodyPath)
+	if err == nil {
+		if metaBytes, err := os.ReadFile(metaPath); err == nil {
+			json.Unmarshal(metaBytes, &cached)
+		}
+	}
+	hasCache := err == nil && cached.URL == file
+
+	if f.offline {
+		if !hasCache {
+			return nil, fmt.Errorf("%s is not cached and --offline was specified", file)
+		}
+		return cachedBody, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, file, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.authorize(req); err != nil {
+		return nil, err
+	}
+	if hasCache && cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && hasCache {
+		return cachedBody, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("GET %s: %s", file, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Failing to cache is not fatal; the response is still usable.
+	f.store(bodyPath, metaPath, cacheEntry{
+		URL:  file,
+		ETag: resp.Header.Get("ETag"),
+	}, body)
+
+	return body, nil
+}
+
+func (f *fetcher) store(bodyPath, metaPath string, entry cacheEntry, body []byte) error {
+	metaBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	for _, file := range []struct {
+		path string
+		data []byte
+	}{{bodyPath, body}, {metaPath, metaBytes}} {
+		staged, err := stageFile(file.path, file.data)
+		if err != nil {
+			return err
+		}
+		if _, err = staged.commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authorize adds the bearer token to https requests for the token's host and
+// otherwise falls back to credentials for the host in the user's .netrc file.
+func (f *fetcher) authorize(req *http.Request) error {
+	if f.token != "" && req.URL.Scheme == "https" && strings.EqualFold(req.URL.Host, f.tokenHost) {
+		req.Header.Set("Authorization", "Bearer "+f.token)
+		return nil
+	}
+
+	login, password, err := netrcCredentials(req.URL.Hostname())
+	if err != nil {
+		return err
+	}
+	if login != "" || password != "" {
+		req.SetBasicAuth(login, password)
+	}
+
+	return nil
+}
+
+// netrcCredentials returns the login and password for host from the file
+// named by $NETRC or ~/.netrc (_netrc on Windows).
+func netrcCredentials(host string) (string, string, error) {
+	path := os.Getenv("NETRC")
+	if path == "" {
+		home, err := homedir.Dir()
+		if err != nil {
+			return "", "", nil
+		}
+		name := ".netrc"
+		if runtime.GOOS == "windows" {
+			name = "_netrc"
+		}
+		path = filepath.Join(home, name)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", "", nil
+		}
+		return "", "", err
+	}
+	defer file.Close()
+
+	var tokens []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, strings.Fields(line)...)
+	}
+	if err = scanner.Err(); err != nil {
+		return "", "", err
+	}
+
+	var login, password string
+	matched, found := false, false
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "machine":
+			if found {
+				return login, password, nil
+			}
+			i++
+			matched = i < len(tokens) && tokens[i] == host
+			found = matched
+		case "default":
+			if found {
+				return login, password, nil
+			}
+			matched, found = true, true
+		case "login", "password", "account":
+			i++
+			if !matched || i >= len(tokens) {
+				continue
+			}
+			if tokens[i-1] == "login" {
+				login = tokens[i]
+			} else if tokens[i-1] == "password" {
+				password = tokens[i]
+			}
+		case "macdef":
+			// Macro definitions are not supported; stop at the first one.
+			return login, password, nil
+		}
+	}
+
+	return login, password, nil
+}
+
+// checkIntegrity verifies data against a Subresource Integrity style value
+// such as "sha256-<base64 digest>".
+func checkIntegrity(name string, data []byte, integrity string) error {
+	if integrity == "" {
+		return nil
+	}
+
+	parts := strings.SplitN(integrity, "-", 2)
+	if len(parts) != 2 || parts[0] != "sha256" {
+		return fmt.Errorf("unsupported integrity value %q, expected sha256-<digest>", integrity)
+	}
+	expected, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return errors.New("integrity digest must be base64 encoded")
+	}
+
+	sum := sha256.Sum256(data)
+	if string(sum[:]) != string(expected) {
+		return fmt.Errorf("integrity check failed for %s: expected %s but got sha256-%s",
+			name, integrity, base64.StdEncoding.EncodeToString(sum[:]))
+	}
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package commands
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNetrcCredentials(t *testing.T) {
+	netrc := `# comment
+machine example.com
+  login alice
+  password s3cret
+
+machine other.example.com login bob password hunter2 account ignored
+default login anonymous password guest
+`
+	tests := []struct {
+		name     string
+		netrc    string
+		host     string
+		login    string
+		password string
+	}{
+		{name: "multi-line entry", netrc: netrc, host: "example.com", login: "alice", password: "s3cret"},
+		{name: "single-line entry", netrc: netrc, host: "other.example.com", login: "bob", password: "hunter2"},
+		{name: "default", netrc: netrc, host: "unknown.example.com", login: "anonymous", password: "guest"},
+		{name: "no match", netrc: "machine example.com login alice password s3cret", host: "example.org"},
+		{name: "macdef stops parsing", netrc: "macdef init\nmachine example.com login alice", host: "example.com"},
+		{name: "missing file", host: "example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".netrc")
+			if tt.netrc != "" {
+				if err := os.WriteFile(path, []byte(tt.netrc), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv("NETRC", path)
+
+			login, password, err := netrcCredentials(tt.host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if login != tt.login || password != tt.password {
+				t.Errorf("netrcCredentials(%q) = %q, %q, want %q, %q",
+					tt.host, login, password, tt.login, tt.password)
+			}
+		})
+	}
+}
+
+func TestAuthorizeScopesToken(t *testing.T) {
+	t.Setenv("NETRC", filepath.Join(t.TempDir(), "missing"))
+
+	tests := []struct {
+		name   string
+		scopes []string
+		url    string
+		want   string
+	}{
+		{name: "remote config host", scopes: []string{"https://example.com/codegen.yaml"}, url: "https://example.com/schema.widl", want: "Bearer token"},
+		{name: "plain http", scopes: []string{"http://example.com/codegen.yaml"}, url: "http://example.com/schema.widl"},
+		{name: "third-party schema", scopes: []string{"https://example.com/codegen.yaml", "https://other.com/schema.widl"}, url: "https://other.com/schema.widl"},
+		{name: "schema of local config", scopes: []string{"codegen.yaml", "https://example.com/schema.widl"}, url: "https://example.com/schema.widl", want: "Bearer token"},
+		{name: "different port", scopes: []string{"https://example.com/codegen.yaml"}, url: "https://example.com:8443/schema.widl"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFetcher(t.TempDir(), "token", false)
+			for _, location := range tt.scopes {
+				f.scopeToken(location)
+			}
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = f.authorize(req); err != nil {
+				t.Fatal(err)
+			}
+			if got := req.Header.Get("Authorization"); got != tt.want {
+				t.Errorf("Authorization = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetcherReadFile(t *testing.T) {
+	t.Setenv("NETRC", filepath.Join(t.TempDir(), "missing"))
+
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if r.URL.Path != "/schema.widl" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(w, `namespace "x"`)
+	}))
+	defer server.Close()
+	home := t.TempDir()
+	schema := server.URL + "/schema.widl"
+
+	f := newFetcher(home, "", false)
+	if _, err := f.readFile(server.URL + "/missing.widl"); err == nil || !strings.Contains(err.Error(), "404 Not Found") {
+		t.Errorf("readFile(missing) error = %v, want 404 Not Found", err)
+	}
+
+	// The first read caches the body and its ETag; the second one
+	// revalidates it and gets the cached body back.
+	for i := 0; i < 2; i++ {
+		requests = nil
+		body, err := f.readFile(schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != `namespace "x"` {
+			t.Errorf("read %d: body = %q", i, body)
+		}
+		if len(requests) != 1 {
+			t.Fatalf("read %d: %d requests, want 1", i, len(requests))
+		}
+		want := ""
+		if i > 0 {
+			want = `"v1"`
+		}
+		if got := requests[0].Header.Get("If-None-Match"); got != want {
+			t.Errorf("read %d: If-None-Match = %q, want %q", i, got, want)
+		}
+	}
+
+	// Offline reads only use the cache.
+	requests = nil
+	offline := newFetcher(home, "", true)
+	body, err := offline.readFile(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `namespace "x"` {
+		t.Errorf("offline body = %q", body)
+	}
+	if _, err = offline.readFile(server.URL + "/other.widl"); err == nil || !strings.Contains(err.Error(), "is not cached") {
+		t.Errorf("offline miss error = %v, want not cached", err)
+	}
+	if len(requests) != 0 {
+		t.Errorf("offline reads made %d requests", len(requests))
+	}
+}
+
+func TestFetcherSendsTokenToScopedHost(t *testing.T) {
+	t.Setenv("NETRC", filepath.Join(t.TempDir(), "missing"))
+
+	var authorization []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = append(authorization, r.Header.Get("Authorization"))
+		io.WriteString(w, "ok")
+	})
+	scoped := httptest.NewTLSServer(handler)
+	defer scoped.Close()
+	other := httptest.NewTLSServer(handler)
+	defer other.Close()
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+
+	f := newFetcher(t.TempDir(), "token", false)
+	// Both TLS servers use the same test certificate.
+	f.client.Transport = scoped.Client().Transport
+	f.scopeToken(scoped.URL + "/codegen.yaml")
+	for _, location := range []string{
+		scoped.URL + "/codegen.yaml",
+		other.URL + "/schema.widl",
+		plain.URL + "/schema.widl",
+	} {
+		if _, err := f.readFile(location); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"Bearer token", "", ""}
+	if strings.Join(authorization, ",") != strings.Join(want, ",") {
+		t.Errorf("Authorization headers = %q, want %q", authorization, want)
+	}
+}
@@ -2,60 +2,211 @@ package commands
 
 import (
 	_ "embed"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/evanw/esbuild/pkg/api"
 	"gopkg.in/yaml.v3"
-	"rogchap.com/v8go"
 
 	"github.com/wapc/cli/pkg/js"
 )
 
-type Context struct{}
+type Context struct {
+	// Version is the version of the CLI.
+	Version string
+}
 
 type GenerateCmd struct {
-	Config string `arg:"" help:"The code generation configuration file" type:"existingfile"`
+	Config  string `arg:"" help:"The code generation configuration file or URL"`
+	Report  string `help:"Print a machine-readable report of the generated files (json)." enum:",json" default:""`
+	Depfile string `help:"Write make-compatible dependency rules for the generated files." type:"path"`
+	Force   bool   `help:"Regenerate all targets even if their inputs are unchanged."`
+
+	OutputDir     string `help:"Resolve generated file paths under this directory." type:"path"`
+	OutputArchive string `help:"Write generated files into this .tar.gz archive instead of the working tree." type:"path"`
+
+	Token   string `help:"Bearer token sent over https to the host of a remote configuration, or of the schema of a local one. Other hosts use .netrc." env:"WAPC_TOKEN"`
+	Offline bool   `help:"Only use cached copies of remote configurations and schemas."`
+	Profile string `help:"The configuration profile to generate with." env:"WAPC_PROFILE"`
+
+	InstallMissing   bool `help:"Install modules that do not satisfy the configuration's requires without prompting."`
+	AllowRemoteHooks bool `help:"Run the hooks of a remote configuration. Hooks are shell commands."`
+
+	LogLevel string        `help:"The lowest level of visitor console messages to print (trace, debug, info, warn, error, silent)." enum:"trace,debug,info,warn,error,silent" default:"info"`
+	Timeout  time.Duration `help:"How long a visitor may run, including its promises and timers (0 for no limit)." default:"1m"`
+	JSEngine string        `help:"The JavaScript engine that runs visitors (v8 or goja). Defaults to v8 when the CLI is built with cgo." env:"WAPC_JS_ENGINE"`
 
-	prettier *js.JS
+	prettier js.JS
 	once     sync.Once
+	version  string
+	out      io.Writer
+	logLevel js.Level
+	fetcher  *fetcher
+	report   GenerateReport
+	depRules []dependencyRule
+	state    *generateState
+	// stageDir holds generated files until they are archived.
+	stageDir string
+	archived []archiveEntry
+
+	// prettierErr is the error from compiling prettier.
+	prettierErr error
+}
+
+// bundledModule is a target's visitor modules compiled to scripts.
+type bundledModule struct {
+	// sources holds one script per stage of the target.
+	sources []string
+	// inputs are the module source files included in the bundles.
+	inputs []string
+}
+
+// generatedTarget is the output of running a target's visitor.
+type generatedTarget struct {
+	files []generatedFile
+	// imports are the WIDL files resolved while parsing the schema and the
+	// files the visitors read from the sandbox.
+	imports []string
+}
+
+// generatedFile is one file of a generated target. Targets whose generator
+// returns several files name them relative to the target's path.
+type generatedFile struct {
+	name   string
+	source string
+}
+
+// GenerationContext describes the target being generated. Visitors receive it
+// as context.generation and generator functions as their context argument.
+type GenerationContext struct {
+	// Filename is the path the output is written to.
+	Filename string `json:"filename"`
+	// Target is the target's key in the configuration.
+	Target     string `json:"target"`
+	Schema     string `json:"schema"`
+	ConfigFile string `json:"configFile"`
+	Profile    string `json:"profile,omitempty"`
+	// Version is the version of the CLI.
+	Version string `json:"version"`
+	// Exists reports whether the output already existed before generating.
+	Exists bool `json:"exists"`
+	// ProjectDir and TemplatesDir are the directories the visitor may read
+	// with wapc.readFile and wapc.readDir. TemplatesDir belongs to the
+	// module of the running stage.
+	ProjectDir   string `json:"projectDir"`
+	TemplatesDir string `json:"templatesDir,omitempty"`
 }
 
 type Config struct {
-	Schema    string                 `json:"schema" yaml:"schema"`
-	Config    map[string]interface{} `json:"config,omitempty" yaml:"config,omitempty"`
-	Generates map[string]Target      `json:"generates" yaml:"generates"`
+	Schema string `json:"schema" yaml:"schema"`
+	// Preset expands into the generates entries of a preset shipped by a
+	// module, e.g. "@wapc/widl-codegen/rust".
+	Preset string `json:"preset,omitempty" yaml:"preset,omitempty"`
+	// Requires maps module names to the version constraints they must
+	// satisfy, e.g. "@wapc/widl-codegen": ">=0.0.5 <0.1".
+	Requires map[string]string `json:"requires,omitempty" yaml:"requires,omitempty"`
+	// SchemaIntegrity optionally pins the schema contents, e.g. "sha256-<base64 digest>".
+	SchemaIntegrity string `json:"schemaIntegrity,omitempty" yaml:"schemaIntegrity,omitempty"`
+	// Definitions lists additional trusted directories that WIDL imports are
+	// resolved from, ahead of ~/.wapc/definitions.
+	Definitions []string               `json:"definitions,omitempty" yaml:"definitions,omitempty"`
+	Config      map[string]interface{} `json:"config,omitempty" yaml:"config,omitempty"`
+	Generates   map[string]Target      `json:"generates" yaml:"generates"`
+	Hooks       Hooks                  `json:"hooks,omitempty" yaml:"hooks,omitempty"`
+	// Profiles are named overrides selected with --profile.
+	Profiles map[string]Profile `json:"profiles,omitempty" yaml:"profiles,omitempty"`
+
+	// order is the order of the generates keys in the configuration file.
+	order []string
+	// presetFile is the file the preset was loaded from.
+	presetFile string
 }
 
 type Target struct {
 	Module       string                 `json:"module" yaml:"module"`
 	VisitorClass string                 `json:"visitorClass" yaml:"visitorClass"`
-	IfNotExists  bool                   `json:"ifNotExists,omitempty" yaml:"ifNotExists,omitempty"`
+	IfNotExists  *bool                  `json:"ifNotExists,omitempty" yaml:"ifNotExists,omitempty"`
+	Disabled     *bool                  `json:"disabled,omitempty" yaml:"disabled,omitempty"`
 	Config       map[string]interface{} `json:"config,omitempty" yaml:"config,omitempty"`
+	Hooks        Hooks                  `json:"hooks,omitempty" yaml:"hooks,omitempty"`
+	// Function names an exported generator function that is used instead of
+	// a visitor class. It is called with the parsed document, the config and
+	// a context object and returns either the generated source or an object
+	// mapping filenames, relative to the target's path, to their contents.
+	Function string `json:"function,omitempty" yaml:"function,omitempty"`
+	// DependsOn lists targets that must be generated before this one. Their
+	// output paths are passed to the visitor in the "dependencies" config map.
+	DependsOn []string `json:"dependsOn,omitempty" yaml:"dependsOn,omitempty"`
+	// Stages replace Module and VisitorClass with several visitors whose
+	// outputs are concatenated in order.
+	Stages []Stage `json:"stages,omitempty" yaml:"stages,omitempty"`
+	// Header and Footer are written before and after the generated source.
+	Header string `json:"header,omitempty" yaml:"header,omitempty"`
+	Footer string `json:"footer,omitempty" yaml:"footer,omitempty"`
 }
 
-const generateTemplate = `import { parse } from "@wapc/widl";
-import { Context, Writer } from "@wapc/widl/ast";
-import { {{visitorClass}} } from "{{module}}";
+// Stage is one visitor of a composite target.
+type Stage struct {
+	Module       string                 `json:"module" yaml:"module"`
+	VisitorClass string                 `json:"visitorClass" yaml:"visitorClass"`
+	Function     string                 `json:"function,omitempty" yaml:"function,omitempty"`
+	Config       map[string]interface{} `json:"config,omitempty" yaml:"config,omitempty"`
+	// Pipe passes the output of the previous stages to this stage's visitor
+	// as context.input and replaces it with the visitor's output.
+	Pipe bool `json:"pipe,omitempty" yaml:"pipe,omitempty"`
+}
+
+// isTrue reports whether an optional flag is set to true. Flags are pointers
+// so that a configuration can turn off a flag its preset turned on.
+func isTrue(flag *bool) bool {
+	return flag != nil && *flag
+}
+
+// stages returns the visitors that generate the target. Targets without
+// stages have a single stage made from Module and VisitorClass.
+func (t *Target) stages() []Stage {
+	if len(t.Stages) > 0 {
+		return t.Stages
+	}
+	return []Stage{{
+		Module:       t.Module,
+		VisitorClass: t.VisitorClass,
+		Function:     t.Function,
+	}}
+}
 
-function resolver(location, from) {
+const resolverSource = `function resolver(location, from) {
   const source = resolverCallback(location, from);
   if (source.startsWith("error: ")) {
     throw source.substring(7);
   }
   return source;
 }
+`
 
-export function generate(widl, config) {
+const generateTemplate = `import { parse } from "@wapc/widl";
+import { Context, Writer } from "@wapc/widl/ast";
+import { {{visitorClass}} } from "{{module}}";
+
+` + resolverSource + `
+` + sandboxSource + `
+export function generate(widl, config, input, generation) {
   const doc = parse(widl, resolver);
   const context = new Context(config);
+  context.generation = generation;
+  if (input !== null && input !== undefined) {
+    context.input = input;
+  }
 
   const writer = new Writer();
   const visitor = new {{visitorClass}}(writer);
@@ -67,6 +218,24 @@ export function generate(widl, config) {
 
 js_exports["generate"] = generate;`
 
+// functionTemplate calls a generator function instead of a visitor.
+const functionTemplate = `import { parse } from "@wapc/widl";
+import { {{function}} } from "{{module}}";
+
+` + resolverSource + `
+` + sandboxSource + `
+export function generate(widl, config, input, generation) {
+  const doc = parse(widl, resolver);
+  const context = Object.assign({}, generation);
+  if (input !== null && input !== undefined) {
+    context.input = input;
+  }
+
+  return {{function}}(doc, config, context);
+}
+
+js_exports["generate"] = generate;`
+
 func (c *GenerateCmd) Run(ctx *Context) error {
 	defer func() {
 		if c.prettier != nil {
@@ -74,18 +243,93 @@ func (c *GenerateCmd) Run(ctx *Context) error {
 		}
 	}()
 
-	configBytes, err := readFile(c.Config)
+	c.version = ctx.Version
+	level, err := js.ParseLevel(c.LogLevel)
+	if err != nil {
+		return err
+	}
+	c.logLevel = level
+	if err = js.SetEngine(c.JSEngine); err != nil {
+		return err
+	}
+
+	// Progress output goes to stderr when a report is requested so that
+	// stdout only contains the report.
+	c.out = os.Stdout
+	if c.Report != "" {
+		c.out = os.Stderr
+	}
+
+	err = c.run()
+	if c.Report == "json" {
+		if err != nil {
+			c.report.Error = err.Error()
+		}
+		if rerr := c.report.writeJSON(os.Stdout); err == nil {
+			err = rerr
+		}
+	}
+
+	return err
+}
+
+func (c *GenerateCmd) run() error {
+	wapcHome, err := ensureHomeDirectory()
+	if err != nil {
+		return err
+	}
+	c.fetcher = newFetcher(wapcHome, c.Token, c.Offline)
+	c.fetcher.scopeToken(c.Config)
+
+	configBytes, err := c.fetcher.readFile(c.Config)
 	if err != nil {
 		return err
 	}
 
+	if c.OutputArchive != "" {
+		if c.stageDir, err = os.MkdirTemp("", "wapc-generate-*"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(c.stageDir)
+	}
+
+	// The state is written to the output directory when one is given so
+	// that a read-only source tree is left alone. Archives are always
+	// regenerated and keep no state.
+	stateDir := configDir(c.Config)
+	if c.OutputDir != "" {
+		stateDir = c.OutputDir
+	} else if c.OutputArchive != "" {
+		stateDir = ""
+	}
+	c.state = loadState(stateDir)
+
 	configs := strings.Split(string(configBytes), "---")
+	if err = checkProfile(configs, c.Profile); err != nil {
+		return err
+	}
 	for _, config := range configs {
 		if err := c.generate(config); err != nil {
+			// Keep the state of targets that were generated successfully.
+			c.state.save()
 			return err
 		}
 	}
 
+	if err = c.state.save(); err != nil {
+		return err
+	}
+
+	if c.OutputArchive != "" {
+		if err = writeArchive(c.OutputArchive, c.archived); err != nil {
+			return err
+		}
+	}
+
+	if c.Depfile != "" {
+		return writeDepfile(c.Depfile, c.depRules)
+	}
+
 	return nil
 }
 
@@ -97,42 +341,232 @@ func (c *GenerateCmd) generate(configYAML string) error {
 	if config.Schema == "" {
 		return errors.New("schema is required")
 	}
+	// Presets are installed locally, so only the document's own hooks
+	// need the user's consent.
+	if isURL(c.Config) && !c.AllowRemoteHooks && config.hasHooks() {
+		return fmt.Errorf("%s has hooks, which run shell commands; pass --allow-remote-hooks to run them", c.Config)
+	}
+
+	homeDir, err := getHomeDirectory(c.out)
+	if err != nil {
+		return err
+	}
+
+	if err = c.checkRequirements(homeDir, config.Requires); err != nil {
+		return err
+	}
+	if err = config.applyPreset(homeDir); err != nil {
+		return err
+	}
 	if len(config.Generates) == 0 {
 		return errors.New("generates is required")
 	}
+	if err = config.applyProfile(c.Profile); err != nil {
+		return err
+	}
+	filenames, err := orderTargets(&config)
+	if err != nil {
+		return err
+	}
+
+	// Paths in the configuration are relative to the configuration itself
+	// rather than the working directory.
+	config.Schema = resolveInput(c.Config, config.Schema)
 
-	schemaBytes, err := readFile(config.Schema)
+	// Pre hooks run before the schema is read so that they can generate or
+	// refresh it.
+	configEnv, err := hookEnv(c.Config, config.Schema, "", config.Config)
 	if err != nil {
 		return err
 	}
+	if err = runHooks(c.out, configDir(c.Config), config.Hooks.Pre, configEnv); err != nil {
+		return err
+	}
+
+	c.fetcher.scopeToken(config.Schema)
+	schemaBytes, err := c.fetcher.readFile(config.Schema)
+	if err != nil {
+		return err
+	}
+	if err = checkIntegrity(config.Schema, schemaBytes, config.SchemaIntegrity); err != nil {
+		return err
+	}
 	schema := string(schemaBytes)
 
-	homeDir, err := getHomeDirectory()
+	// Visitors may read files under the project directory.
+	projectDir, err := filepath.Abs(configDir(c.Config))
 	if err != nil {
 		return err
 	}
-	srcDir := filepath.Join(homeDir, "src")
 
-	for filename, target := range config.Generates {
-		if target.Module == "" {
-			return fmt.Errorf("module is required for %s", filename)
+	// WIDL imports may only be resolved from the trusted definitions
+	// directories listed in the configuration and ~/.wapc/definitions.
+	// They are absolute so that recorded imports do not depend on the
+	// working directory.
+	definitions := make([]string, 0, len(config.Definitions)+1)
+	for _, dir := range config.Definitions {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(projectDir, dir)
 		}
-		if target.VisitorClass == "" {
-			return fmt.Errorf("visitorClass is required for %s", filename)
+		definitions = append(definitions, dir)
+	}
+	definitions = append(definitions, filepath.Join(homeDir, "definitions"))
+
+	type pendingFile struct {
+		output string
+		file   *stagedFile
+	}
+	type pendingTarget struct {
+		output    string
+		files     []pendingFile
+		result    *TargetResult
+		inputs    []string
+		hash      string
+		imports   []string
+		postHooks []string
+		env       []string
+	}
+	var pending []pendingTarget
+	defer func() {
+		for _, p := range pending {
+			for _, f := range p.files {
+				f.file.discard()
+			}
 		}
-		if target.IfNotExists {
-			_, err := os.Stat(filename)
-			if err != nil && !os.IsNotExist(err) {
-				return err
+	}()
+
+	// flush formats and writes the pending targets and runs their post hooks.
+	flush := func() error {
+		// Formatters run on the staged files before any of them replaces its
+		// output, so a failing formatter leaves every output untouched.
+		// Formatters that read other files see their previous versions.
+		for _, p := range pending {
+			start := time.Now()
+			for _, f := range p.files {
+				ext := filepath.Ext(f.output)
+				switch ext {
+				case ".rs":
+					fmt.Fprintf(c.out, "Formatting %s...\n", f.output)
+					if err := formatRust(c.out, f.file.tempname); err != nil {
+						return p.result.fail(err)
+					}
+				case ".go":
+					fmt.Fprintf(c.out, "Formatting %s...\n", f.output)
+					if err := formatGolang(c.out, f.file.tempname); err != nil {
+						return p.result.fail(err)
+					}
+				}
 			}
-			if err == nil {
-				fmt.Printf("Skipping %s...\n", filename)
-				continue
+			p.result.Durations.Format += since(start)
+		}
+
+		// Only replace files whose contents actually changed so that build
+		// tools relying on modification times do not rebuild needlessly.
+		for _, p := range pending {
+			entry := targetState{
+				Inputs:  p.hash,
+				Imports: p.imports,
+			}
+			var files map[string]string
+			p.result.Status = StatusUnchanged
+			for _, f := range p.files {
+				written, err := f.file.commit()
+				if err != nil {
+					return p.result.fail(err)
+				}
+				if written {
+					p.result.Status = StatusWritten
+				} else {
+					fmt.Fprintf(c.out, "Unchanged %s...\n", f.output)
+				}
+
+				if c.stageDir != "" {
+					c.archived = append(c.archived, archiveEntry{
+						name: f.output,
+						path: f.file.filename,
+					})
+				} else {
+					output, err := hashFile(f.output)
+					if err != nil {
+						return p.result.fail(err)
+					}
+					if f.output == p.output {
+						entry.Output = output
+					} else {
+						if files == nil {
+							files = make(map[string]string, len(p.files))
+						}
+						files[f.output] = output
+					}
+				}
+				c.addDependencyRule(config, f.output, p.inputs, p.imports)
+			}
+			if c.stageDir == "" {
+				c.state.setTarget(p.output, entry, files)
+			}
+		}
+
+		for _, p := range pending {
+			if err := runHooks(c.out, configDir(c.Config), p.postHooks, p.env); err != nil {
+				return p.result.fail(err)
+			}
+		}
+
+		pending = nil
+		return nil
+	}
+
+	for _, filename := range filenames {
+		target := config.Generates[filename]
+		output, err := c.outputPath(filename)
+		if err != nil {
+			return err
+		}
+		result := c.report.add(output, target)
+		for i, stage := range target.stages() {
+			name := filename
+			if len(target.Stages) > 0 {
+				name = fmt.Sprintf("stage %d of %s", i+1, filename)
+			}
+			if stage.Module == "" {
+				return result.fail(fmt.Errorf("module is required for %s", name))
+			}
+			if stage.VisitorClass == "" && stage.Function == "" {
+				return result.fail(fmt.Errorf("visitorClass or function is required for %s", name))
 			}
+			if stage.VisitorClass != "" && stage.Function != "" {
+				return result.fail(fmt.Errorf("visitorClass and function cannot both be set for %s", name))
+			}
+		}
+		// Archived outputs are never on disk.
+		exists := false
+		if c.stageDir == "" {
+			_, err := os.Stat(output)
+			if err != nil && !os.IsNotExist(err) {
+				return result.fail(err)
+			}
+			exists = err == nil
+		}
+		if isTrue(target.IfNotExists) && exists {
+			fmt.Fprintf(c.out, "Skipping %s...\n", output)
+			result.Status = StatusSkipped
+			continue
+		}
+		generation := GenerationContext{
+			Filename:   output,
+			Target:     filename,
+			Schema:     config.Schema,
+			ConfigFile: c.Config,
+			Version:    c.version,
+			Exists:     exists,
+			ProjectDir: projectDir,
+		}
+		if len(config.Profiles) > 0 {
+			generation.Profile = c.Profile
 		}
 
 		// Merge global config into target config
-		if target.Config == nil && config.Config != nil {
+		if target.Config == nil {
 			target.Config = make(map[string]interface{}, len(config.Config))
 		}
 		for k, v := range config.Config {
@@ -141,12 +575,191 @@ func (c *GenerateCmd) generate(configYAML string) error {
 			}
 		}
 
-		fmt.Printf("Generating %s...\n", filename)
+		// The dependencies' output paths vary with the working directory,
+		// so they are not hashed; their names are.
+		hashed := target
+		if len(target.DependsOn) > 0 {
+			// Dependencies are written before this target runs so that it
+			// can consume their output.
+			if err = flush(); err != nil {
+				return err
+			}
+			if _, exists := target.Config["dependencies"]; !exists {
+				dependencies := make(map[string]interface{}, len(target.DependsOn))
+				for _, dependency := range target.DependsOn {
+					if dependencies[dependency], err = c.outputPath(dependency); err != nil {
+						return result.fail(err)
+					}
+				}
+				config := make(map[string]interface{}, len(target.Config)+1)
+				for k, v := range target.Config {
+					config[k] = v
+				}
+				config["dependencies"] = dependencies
+				target.Config = config
+			}
+		}
+
+		bundle, err := c.bundleTarget(homeDir, target, result)
+		if err != nil {
+			return result.fail(err)
+		}
+
+		// Archived outputs are not on disk to compare against, so they are
+		// always regenerated.
+		bundleSource := strings.Join(bundle.sources, "\x00")
+		hash, err := inputsHash(c.version, schema, hashed, c.state.hashedContext(generation), bundleSource, c.state.target(output).Imports)
+		if err != nil {
+			return result.fail(err)
+		}
+		if !c.Force && c.stageDir == "" && c.state.upToDate(output, hash) {
+			fmt.Fprintf(c.out, "Up to date %s...\n", output)
+			result.Status = StatusUnchanged
+			for _, filename := range c.state.outputs(output) {
+				c.addDependencyRule(config, filename, bundle.inputs, c.state.target(output).Imports)
+			}
+			continue
+		}
+
+		// Hooks only run for targets that are generated. Archived outputs
+		// are only on disk in the staging directory, where post hooks can
+		// still change them before they are archived.
+		hookFilename := output
+		if c.stageDir != "" {
+			hookFilename = filepath.Join(c.stageDir, output)
+		}
+		targetEnv, err := hookEnv(c.Config, config.Schema, hookFilename, target.Config)
+		if err != nil {
+			return result.fail(err)
+		}
+		if err = runHooks(c.out, configDir(c.Config), target.Hooks.Pre, targetEnv); err != nil {
+			return result.fail(err)
+		}
+
+		fmt.Fprintf(c.out, "Generating %s...\n", output)
+		generated, err := c.generateTarget(homeDir, definitions, schema, target, bundle, &generation, result)
+		if err != nil {
+			return result.fail(err)
+		}
+
+		// Rehash with the imports and files that were actually read this
+		// time.
+		hash, err = inputsHash(c.version, schema, hashed, c.state.hashedContext(generation), bundleSource, generated.imports)
+		if err != nil {
+			return result.fail(err)
+		}
+
+		// Generated files are staged next to their destination and only
+		// replace it once formatting has finished, so an interrupted run
+		// never leaves a partially written file behind.
+		pending = append(pending, pendingTarget{
+			output:    output,
+			result:    result,
+			inputs:    bundle.inputs,
+			hash:      hash,
+			imports:   generated.imports,
+			postHooks: target.Hooks.Post,
+			env:       targetEnv,
+		})
+		p := &pending[len(pending)-1]
+		for _, generatedFile := range generated.files {
+			path := output
+			if generatedFile.name != "" {
+				if path, err = outputFile(output, generatedFile.name); err != nil {
+					return result.fail(err)
+				}
+				result.Files = append(result.Files, path)
+			}
+			dest := path
+			if c.stageDir != "" {
+				dest = filepath.Join(c.stageDir, path)
+			}
+			file, err := stageFile(dest, []byte(generatedFile.source))
+			if err != nil {
+				return result.fail(err)
+			}
+			p.files = append(p.files, pendingFile{
+				output: path,
+				file:   file,
+			})
+		}
+	}
+
+	if err = flush(); err != nil {
+		return err
+	}
+
+	return runHooks(c.out, configDir(c.Config), config.Hooks.Post, configEnv)
+}
+
+// outputPath returns the path a target is written to. Relative paths are
+// resolved against the configuration's directory. When an output directory or
+// archive is used, targets must stay inside of it.
+func (c *GenerateCmd) outputPath(filename string) (string, error) {
+	if c.OutputDir == "" && c.OutputArchive == "" {
+		if filepath.IsAbs(filename) {
+			return filename, nil
+		}
+		return filepath.Join(configDir(c.Config), filename), nil
+	}
+
+	clean := filepath.Clean(filename)
+	if isOutside(clean) {
+		return "", fmt.Errorf("%s is outside of the output directory", filename)
+	}
+	if c.OutputArchive != "" {
+		return clean, nil
+	}
+
+	return filepath.Join(c.OutputDir, clean), nil
+}
+
+// outputFile returns the path of a file named by a generator that returned
+// several files. The files are placed under the target's path and must stay
+// inside of it.
+func outputFile(dir, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || clean == "." || isOutside(clean) {
+		return "", fmt.Errorf("%s is outside of %s", name, dir)
+	}
+
+	return filepath.Join(dir, clean), nil
+}
+
+func (c *GenerateCmd) addDependencyRule(config Config, filename string, inputs, imports []string) {
+	if c.OutputArchive != "" {
+		filename = c.OutputArchive
+	}
+	var prerequisites []string
+	for _, input := range []string{c.Config, config.presetFile, config.Schema} {
+		if input != "" && !isURL(input) {
+			prerequisites = append(prerequisites, input)
+		}
+	}
+	prerequisites = append(prerequisites, inputs...)
+	c.depRules = append(c.depRules, dependencyRule{
+		target:        filename,
+		prerequisites: append(prerequisites, imports...),
+	})
+}
+
+// bundleTarget bundles the visitor module of each of the target's stages
+// and its dependencies into a single script.
+func (c *GenerateCmd) bundleTarget(homeDir string, target Target, result *TargetResult) (*bundledModule, error) {
+	srcDir := filepath.Join(homeDir, "src")
+
+	start := time.Now()
+	var bundle bundledModule
+	for _, stage := range target.stages() {
 		generateTS := generateTemplate
-		generateTS = strings.Replace(generateTS, "{{module}}", target.Module, 1)
-		generateTS = strings.Replace(generateTS, "{{visitorClass}}", target.VisitorClass, -1)
+		if stage.Function != "" {
+			generateTS = functionTemplate
+		}
+		generateTS = strings.Replace(generateTS, "{{module}}", stage.Module, 1)
+		generateTS = strings.Replace(generateTS, "{{visitorClass}}", stage.VisitorClass, -1)
+		generateTS = strings.Replace(generateTS, "{{function}}", stage.Function, -1)
 
-		result := api.Build(api.BuildOptions{
+		buildResult := api.Build(api.BuildOptions{
 			Stdin: &api.StdinOptions{
 				Contents:   generateTS,
 				Sourcefile: "generate.ts",
@@ -154,137 +767,264 @@ func (c *GenerateCmd) generate(configYAML string) error {
 			},
 			Bundle:    true,
 			NodePaths: []string{srcDir},
-			LogLevel:  api.LogLevelInfo,
+			// Paths in the bundle are relative to the working directory,
+			// which must not change the bundle and with it the inputs hash.
+			AbsWorkingDir: srcDir,
+			LogLevel:      api.LogLevelInfo,
+			Metafile:      true,
+			// Node's built-in modules are left to the require shim in pkg/js.
+			Platform: api.PlatformNode,
+			Format:   api.FormatIIFE,
 		})
-		if len(result.Errors) > 0 {
-			return fmt.Errorf("esbuild returned errors: %v", result.Errors)
+		if len(buildResult.Errors) > 0 {
+			return nil, fmt.Errorf("esbuild returned errors: %v", buildResult.Errors)
 		}
-		if len(result.OutputFiles) != 1 {
-			return errors.New("esbuild did not produce exactly 1 output file")
+		if len(buildResult.OutputFiles) != 1 {
+			return nil, errors.New("esbuild did not produce exactly 1 output file")
 		}
 
-		bundle := string(result.OutputFiles[0].Contents)
+		inputs, err := metafileInputs(srcDir, buildResult.Metafile)
+		if err != nil {
+			return nil, err
+		}
+		bundle.sources = append(bundle.sources, string(buildResult.OutputFiles[0].Contents))
+		bundle.inputs = mergeSorted(bundle.inputs, inputs)
+	}
+	result.Durations.Bundle = since(start)
 
-		definitionsDir := filepath.Join(homeDir, "definitions")
+	return &bundle, nil
+}
 
-		resolverCallback := func(info *v8go.FunctionCallbackInfo) *v8go.Value {
-			iso, err := info.Context().Isolate()
-			if err != nil {
-				return nil
+// generateTarget runs the bundled visitor modules against the schema and
+// returns the generated files.
+func (c *GenerateCmd) generateTarget(homeDir string, definitions []string, schema string, target Target, bundle *bundledModule, generation *GenerationContext, result *TargetResult) (*generatedTarget, error) {
+	var imports []string
+	var source string
+	var files map[string]string
+	stages := target.stages()
+	for i, stage := range stages {
+		config := target.Config
+		if len(stage.Config) > 0 {
+			config = make(map[string]interface{}, len(target.Config)+len(stage.Config))
+			for k, v := range target.Config {
+				config[k] = v
 			}
-
-			if len(info.Args()) < 1 {
-				value, _ := v8go.NewValue(iso, "error: resolve: invalid arguments")
-				return value
+			for k, v := range stage.Config {
+				config[k] = v
 			}
+		}
 
-			location := info.Args()[0].String()
+		var input interface{}
+		if stage.Pipe {
+			input = source
+		}
 
-			loc := filepath.Join(definitionsDir, filepath.Join(strings.Split(location, "/")...))
-			if filepath.Ext(loc) != ".widl" {
-				widlLoc := loc + ".widl"
-				found := false
-				stat, err := os.Stat(widlLoc)
-				if err == nil && !stat.IsDir() {
-					found = true
-					loc = widlLoc
-				}
+		stageGeneration := *generation
+		stageGeneration.TemplatesDir = templatesDir(homeDir, stage.Module)
 
-				if !found {
-					stat, err := os.Stat(loc)
-					if err != nil {
-						value, _ := v8go.NewValue(iso, fmt.Sprintf("error: %v", err))
-						return value
-					}
-					if stat.IsDir() {
-						loc = filepath.Join(loc, "index.widl")
-					} else {
-						loc += ".widl"
-					}
-				}
-			}
+		var output string
+		res, stageImports, err := c.runVisitor(definitions, bundle.sources[i], schema, config, input, &stageGeneration, result)
+		if err != nil {
+			return nil, err
+		}
+		imports = mergeSorted(imports, stageImports)
 
-			data, err := os.ReadFile(loc)
-			if err != nil {
-				value, _ := v8go.NewValue(iso, fmt.Sprintf("error: %v", err))
-				return value
+		if stage.Function == "" {
+			var ok bool
+			if output, ok = res.(string); !ok {
+				return nil, errors.New("generate did not return a string")
+			}
+		} else {
+			var stageFiles map[string]string
+			if output, stageFiles, err = functionOutput(stage.Function, res); err != nil {
+				return nil, err
+			}
+			if stageFiles != nil {
+				if len(stages) > 1 {
+					return nil, fmt.Errorf("%s returned several files, which only single-stage targets support", stage.Function)
+				}
+				files = stageFiles
+				continue
 			}
-
-			value, _ := v8go.NewValue(iso, string(data))
-			return value
 		}
 
-		j, err := js.Compile(bundle, map[string]v8go.FunctionCallback{
-			"resolverCallback": resolverCallback,
-		})
-		if err != nil {
-			return err
+		if stage.Pipe {
+			source = output
+		} else {
+			source += output
 		}
-		defer j.Dispose()
+	}
 
-		if target.Config == nil {
-			target.Config = map[string]interface{}{}
+	generated := generatedTarget{
+		imports: imports,
+	}
+	if files == nil {
+		generated.files = []generatedFile{{source: source}}
+	} else {
+		names := make([]string, 0, len(files))
+		for name := range files {
+			names = append(names, name)
 		}
-		res, err := j.Invoke("generate", schema, target.Config)
-		if err != nil {
-			if jserr, ok := err.(*v8go.JSError); ok {
-				jserr.Message = strings.TrimPrefix(jserr.Message, "Error: ")
-			}
-			return err
+		sort.Strings(names)
+		for _, name := range names {
+			generated.files = append(generated.files, generatedFile{
+				name:   name,
+				source: files[name],
+			})
 		}
+	}
+
+	for i := range generated.files {
+		file := &generated.files[i]
+		file.source = target.Header + file.source + target.Footer
 
-		source := res.(string)
-		ext := filepath.Ext(filename)
+		ext := filepath.Ext(generation.Filename)
+		if file.name != "" {
+			ext = filepath.Ext(file.name)
+		}
 		switch ext {
 		case ".ts":
-			source, err = c.formatTypeScript(source)
+			start := time.Now()
+			var err error
+			file.source, err = c.formatTypeScript(file.source)
 			if err != nil {
-				return err
+				return nil, err
 			}
+			result.Durations.Format += since(start)
 		}
+	}
 
-		dir := filepath.Dir(filename)
-		if dir != "" {
-			if err = os.MkdirAll(dir, 0777); err != nil {
-				return err
+	return &generated, nil
+}
+
+// functionOutput checks the result of a generator function, which is either
+// the generated source or an object mapping filenames to contents.
+func functionOutput(function string, result interface{}) (string, map[string]string, error) {
+	switch v := result.(type) {
+	case string:
+		return v, nil, nil
+	case map[string]interface{}:
+		files := make(map[string]string, len(v))
+		for name, contents := range v {
+			source, ok := contents.(string)
+			if !ok {
+				return "", nil, fmt.Errorf("%s returned a non-string for %s", function, name)
 			}
+			files[name] = source
 		}
-		if err = os.WriteFile(filename, []byte(source), 0666); err != nil {
-			return err
+		return "", files, nil
+	}
+
+	return "", nil, fmt.Errorf("%s must return a string or an object of strings", function)
+}
+
+// runVisitor runs a bundled visitor module and returns its result and the
+// WIDL files it imported or read from the sandbox, which are inputs of the
+// target. input is passed to the visitor as context.input
+// unless it is nil.
+func (c *GenerateCmd) runVisitor(definitions []string, bundle, schema string, config map[string]interface{}, input interface{}, generation *GenerationContext, result *TargetResult) (interface{}, []string, error) {
+	var imports []string
+
+	resolverCallback := func(args ...string) (string, error) {
+		if len(args) < 1 {
+			return "", errors.New("resolve: invalid arguments")
 		}
+
+		loc, err := resolveImport(definitions, args[0])
+		if err != nil {
+			return "", err
+		}
+
+		data, err := os.ReadFile(loc)
+		if err != nil {
+			return "", err
+		}
+		imports = append(imports, loc)
+
+		return string(data), nil
 	}
 
-	// Some CLI-based formatters actually check for types referenced in other files
-	// so we must call these after all the files are generated.
-	for filename := range config.Generates {
-		ext := filepath.Ext(filename)
-		switch ext {
-		case ".rs":
-			fmt.Printf("Formatting %s...\n", filename)
-			if err = formatRust(filename); err != nil {
-				return err
-			}
-		case ".go":
-			fmt.Printf("Formatting %s...\n", filename)
-			if err = formatGolang(filename); err != nil {
-				return err
-			}
+	fs := newSandbox(generation.ProjectDir, generation.TemplatesDir)
+
+	start := time.Now()
+	j, err := js.Compile(bundle, c.console(generation.Filename), map[string]js.Func{
+		"resolverCallback": resolverCallback,
+	}, fs.callbacks())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer j.Dispose()
+	j.SetTimeout(c.Timeout)
+	result.Durations.Compile += since(start)
+
+	start = time.Now()
+	res, err := j.Invoke("generate", schema, config, input, generation)
+	if err != nil {
+		if jserr, ok := err.(*js.Error); ok {
+			jserr.Message = strings.TrimPrefix(jserr.Message, "Error: ")
 		}
+		return nil, nil, err
 	}
+	result.Durations.Invoke += since(start)
 
-	return nil
+	sort.Strings(imports)
+	sort.Strings(fs.reads)
+	return res, mergeSorted(imports, fs.reads), nil
+}
+
+// mergeSorted merges two sorted lists of paths and removes duplicates.
+func mergeSorted(a, b []string) []string {
+	merged := make([]string, 0, len(a)+len(b))
+	merged = append(merged, a...)
+	merged = append(merged, b...)
+	sort.Strings(merged)
+
+	unique := merged[:0]
+	for i, path := range merged {
+		if i == 0 || path != merged[i-1] {
+			unique = append(unique, path)
+		}
+	}
+
+	return unique
+}
+
+// metafileInputs returns the absolute paths of the source files listed in
+// an esbuild metafile built in dir.
+func metafileInputs(dir, metafile string) ([]string, error) {
+	var meta struct {
+		Inputs map[string]json.RawMessage `json:"inputs"`
+	}
+	if err := json.Unmarshal([]byte(metafile), &meta); err != nil {
+		return nil, err
+	}
+
+	inputs := make([]string, 0, len(meta.Inputs))
+	for input := range meta.Inputs {
+		abs := input
+		if !filepath.IsAbs(abs) {
+			abs = filepath.Join(dir, input)
+		}
+		// Skip virtual inputs such as the generated entry point.
+		if _, err := os.Stat(abs); err != nil {
+			continue
+		}
+		inputs = append(inputs, abs)
+	}
+	sort.Strings(inputs)
+
+	return inputs, nil
 }
 
 //go:embed prettier.js
 var prettierSource string
 
 func (c *GenerateCmd) formatTypeScript(source string) (string, error) {
-	var err error
 	c.once.Do(func() {
-		c.prettier, err = js.Compile(prettierSource)
+		c.prettier, c.prettierErr = js.Compile(prettierSource, c.console("prettier"))
 	})
-	if err != nil {
-		return "", err
+	if c.prettierErr != nil {
+		return "", c.prettierErr
 	}
 
 	res, err := c.prettier.Invoke("formatTypeScript", source)
@@ -295,30 +1035,60 @@ func (c *GenerateCmd) formatTypeScript(source string) (string, error) {
 	return res.(string), nil
 }
 
-func formatRust(filename string) error {
+// console returns the console for scripts, tagging messages with prefix.
+func (c *GenerateCmd) console(prefix string) *js.Console {
+	return &js.Console{
+		Out:    os.Stderr,
+		Level:  c.logLevel,
+		Prefix: prefix,
+	}
+}
+
+func formatRust(out io.Writer, filename string) error {
 	cmd := exec.Command("rustfmt", filename)
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = out
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
 
-func formatGolang(filename string) error {
+func formatGolang(out io.Writer, filename string) error {
 	cmd := exec.Command("gofmt", "-w", filename)
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = out
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
 
-func readFile(file string) ([]byte, error) {
-	if strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://") {
-		resp, err := http.Get(file)
+func isURL(file string) bool {
+	return strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://")
+}
+
+// configDir returns the directory of a local configuration file. Remote
+// configurations use the working directory.
+func configDir(configLocation string) string {
+	if isURL(configLocation) {
+		return "."
+	}
+	return filepath.Dir(configLocation)
+}
+
+// resolveInput resolves a path referenced by a configuration relative to the
+// configuration's location, which may be a URL.
+func resolveInput(configLocation, path string) string {
+	if isURL(path) || filepath.IsAbs(path) {
+		return path
+	}
+
+	if isURL(configLocation) {
+		base, err := url.Parse(configLocation)
 		if err != nil {
-			return nil, err
+			return path
 		}
-		defer resp.Body.Close()
-
-		return io.ReadAll(resp.Body)
+		ref, err := url.Parse(filepath.ToSlash(path))
+		if err != nil {
+			return path
+		}
+		return base.ResolveReference(ref).String()
 	}
 
-	return os.ReadFile(file)
+	return filepath.Join(filepath.Dir(configLocation), path)
 }
@@ -2,6 +2,7 @@ package commands
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -18,13 +19,15 @@ var baseDependencies = map[string][]string{
 	},
 }
 
-func getHomeDirectory() (string, error) {
+// getHomeDirectory returns the waPC home directory after installing missing
+// base dependencies. Installation progress is written to out.
+func getHomeDirectory(out io.Writer) (string, error) {
 	wapcHome, err := ensureHomeDirectory()
 	if err != nil {
 		return "", err
 	}
 
-	err = checkDependencies(wapcHome, false)
+	err = checkDependencies(out, wapcHome, false)
 
 	return wapcHome, err
 }
@@ -86,7 +89,7 @@ func ensureHomeDirectory() (string, error) {
 	return wapcHome, nil
 }
 
-func checkDependencies(wapcHome string, forceDownload bool) error {
+func checkDependencies(out io.Writer, wapcHome string, forceDownload bool) error {
 	missing := make(map[string]struct{}, len(baseDependencies))
 	for dependency, checks := range baseDependencies {
 		for _, check := range checks {
@@ -99,10 +102,11 @@ func checkDependencies(wapcHome string, forceDownload bool) error {
 	}
 
 	if len(missing) > 0 {
-		fmt.Println("Installing base dependencies...")
+		fmt.Fprintln(out, "Installing base dependencies...")
 		for dependency := range missing {
 			cmd := InstallCmd{
 				Location: dependency,
+				out:      out,
 			}
 			if err := cmd.doRun(&Context{}, wapcHome); err != nil {
 				return err
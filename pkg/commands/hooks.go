@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// Hooks are shell commands that run before and after code generation.
+type Hooks struct {
+	Pre  []string `json:"pre,omitempty" yaml:"pre,omitempty"`
+	Post []string `json:"post,omitempty" yaml:"post,omitempty"`
+}
+
+// hasHooks reports whether the configuration or any of its targets has
+// hooks.
+func (c *Config) hasHooks() bool {
+	if len(c.Hooks.Pre) > 0 || len(c.Hooks.Post) > 0 {
+		return true
+	}
+	for _, target := range c.Generates {
+		if len(target.Hooks.Pre) > 0 || len(target.Hooks.Post) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hookEnv returns the environment variables passed to hooks. filename is
+// empty for configuration-level hooks.
+func hookEnv(configFile, schema, filename string, config map[string]interface{}) ([]string, error) {
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	env := []string{
+		"WAPC_CONFIG_FILE=" + hookPath(configFile),
+		"WAPC_SCHEMA=" + hookPath(schema),
+		"WAPC_CONFIG=" + string(configJSON),
+	}
+	if filename != "" {
+		env = append(env, "WAPC_FILENAME="+hookPath(filename))
+	}
+
+	return env, nil
+}
+
+// hookPath makes a local path absolute because hooks do not run in the
+// working directory.
+func hookPath(path string) string {
+	if isURL(path) {
+		return path
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// runHooks runs each command in dir, which is the configuration's directory,
+// in order and stops at the first failure.
+func runHooks(out io.Writer, dir string, commands []string, env []string) error {
+	for _, command := range commands {
+		fmt.Fprintf(out, "Running %s...\n", command)
+		cmd := shellCommand(command)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Stdout = out
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("hook %q failed: %w", command, err)
+		}
+	}
+
+	return nil
+}
+
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
@@ -15,6 +15,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/google/go-github/v33/github"
 )
 
@@ -23,19 +24,22 @@ type InstallCmd struct {
 	Release  string `arg:"" help:"The release tag to install." optional:""`
 
 	netClient http.Client
+	// out receives progress messages. It defaults to stdout.
+	out io.Writer
 }
 
 type releaseInfo struct {
 	Org        string
 	Module     string
 	Tag        string
+	Version    string
 	Directory  string
 	ZipURL     string
 	TarballURL string
 }
 
 func (c *InstallCmd) Run(ctx *Context) error {
-	homeDir, err := getHomeDirectory()
+	homeDir, err := getHomeDirectory(c.output())
 	if err != nil {
 		return err
 	}
@@ -55,7 +59,7 @@ func (c *InstallCmd) doRun(ctx *Context, homeDir string) error {
 		return err
 	}
 
-	fmt.Printf("Installing %s/%s %s...\n", release.Org, release.Module, release.Tag)
+	fmt.Fprintf(c.output(), "Installing %s/%s %s...\n", release.Org, release.Module, release.Tag)
 
 	if release.Directory != "" {
 		moduleSubDir := release.Module
@@ -63,11 +67,15 @@ func (c *InstallCmd) doRun(ctx *Context, homeDir string) error {
 			moduleSubDir = filepath.Join(release.Org, release.Module)
 		}
 
-		return c.installDir(
+		if err = c.installDir(
 			release.Directory,
 			homeDir,
 			moduleSubDir,
-		)
+		); err != nil {
+			return err
+		}
+
+		return c.recordInstall(homeDir, release)
 	}
 
 	f, err := os.CreateTemp("", "install-*")
@@ -108,7 +116,7 @@ func (c *InstallCmd) doRun(ctx *Context, homeDir string) error {
 	}
 	defer os.RemoveAll(downloadDir)
 
-	fmt.Printf("Extracting %s...\n", filepath.Base(downloadURL))
+	fmt.Fprintf(c.output(), "Extracting %s...\n", filepath.Base(downloadURL))
 	switch fileType {
 	case "tar.gz":
 		if err = c.extractTarball(f.Name(), downloadDir); err != nil {
@@ -148,7 +156,25 @@ func (c *InstallCmd) doRun(ctx *Context, homeDir string) error {
 		}
 	}
 
-	return nil
+	return c.recordInstall(homeDir, release)
+}
+
+// recordInstall stores the installed version so that codegen configurations
+// can check it against their requirements.
+func (c *InstallCmd) recordInstall(homeDir string, release *releaseInfo) error {
+	name := release.Module
+	if release.Org != "" {
+		name = release.Org + "/" + release.Module
+	}
+	version := release.Version
+	if version == "" {
+		version = release.Tag
+	}
+
+	return recordInstalledModule(homeDir, name, installedModule{
+		Version:  version,
+		Location: c.Location,
+	})
 }
 
 func (c *InstallCmd) getReleaseInfo(location, releaseTag string) (*releaseInfo, error) {
@@ -194,16 +220,15 @@ func (c *InstallCmd) getReleaseInfoFromNPM(location, releaseTag string) (*releas
 		releaseTag = "latest"
 	}
 
-        npmHost, present := os.LookupEnv("NPM_REG")
-        if !present {
-          npmHost = "https://registry.npmjs.org"
-        }
-	npmURL := fmt.Sprintf("%s/%s/%s/", npmHost, location, releaseTag)
+	npmURL := fmt.Sprintf("%s/%s/%s/", npmRegistry(), location, releaseTag)
 	resp, err := c.netClient.Get(npmURL)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("GET %s: %s", npmURL, resp.Status)
+	}
 
 	var v version
 	if err = json.NewDecoder(resp.Body).Decode(&v); err != nil {
@@ -230,6 +255,52 @@ func (c *InstallCmd) getReleaseInfoFromNPM(location, releaseTag string) (*releas
 	}, nil
 }
 
+// findNPMVersion returns the newest published version of an NPM module that
+// satisfies constraint.
+func (c *InstallCmd) findNPMVersion(location string, constraint *semver.Constraints) (string, error) {
+	c.createHTTPClient()
+
+	npmURL := fmt.Sprintf("%s/%s", npmRegistry(), location)
+	resp, err := c.netClient.Get(npmURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("GET %s: %s", npmURL, resp.Status)
+	}
+
+	var packument struct {
+		Versions map[string]json.RawMessage `json:"versions"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&packument); err != nil {
+		return "", err
+	}
+
+	var newest *semver.Version
+	for v := range packument.Versions {
+		version, err := semver.NewVersion(v)
+		if err != nil || !constraint.Check(version) {
+			continue
+		}
+		if newest == nil || version.GreaterThan(newest) {
+			newest = version
+		}
+	}
+	if newest == nil {
+		return "", fmt.Errorf("no published version of %s satisfies %s", location, constraint)
+	}
+
+	return newest.Original(), nil
+}
+
+func npmRegistry() string {
+	if npmHost, present := os.LookupEnv("NPM_REG"); present {
+		return npmHost
+	}
+	return "https://registry.npmjs.org"
+}
+
 func (c *InstallCmd) getReleaseInfoFromGithub(location, releaseTag string) (*releaseInfo, error) {
 	repoParts := strings.Split(location, "/")
 	if len(repoParts) != 2 {
@@ -316,7 +387,7 @@ func (c *InstallCmd) installDir(src string, dest string, modulePart string) erro
 
 		base := filepath.Base(entry.Name())
 		if _, ok := extensionDirectories[base]; ok {
-			fmt.Printf("Copying into ~/.wapc/%s...\n", base)
+			fmt.Fprintf(c.output(), "Copying into ~/.wapc/%s...\n", base)
 			destDir := filepath.Join(dest, base, modulePart)
 			if err = os.RemoveAll(destDir); err != nil {
 				return err
@@ -490,6 +561,14 @@ func (c *InstallCmd) copyRecursive(source, destination string) error {
 	})
 }
 
+// output returns where progress messages are written.
+func (c *InstallCmd) output() io.Writer {
+	if c.out == nil {
+		return os.Stdout
+	}
+	return c.out
+}
+
 func (c *InstallCmd) createHTTPClient() {
 	var netTransport = &http.Transport{
 		Dial: (&net.Dialer{
@@ -511,7 +590,8 @@ func readPackage(dir string, release *releaseInfo) error {
 	}
 
 	type packageJSON struct {
-		Name string `json:"name"`
+		Name    string `json:"name"`
+		Version string `json:"version"`
 	}
 
 	var contents packageJSON
@@ -519,6 +599,10 @@ func readPackage(dir string, release *releaseInfo) error {
 		return err
 	}
 
+	if contents.Version != "" {
+		release.Version = contents.Version
+	}
+
 	if contents.Name == "" {
 		return nil
 	}
@@ -0,0 +1,53 @@
+package commands
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// modulesFilename is the manifest in the wapc home directory that records
+// the version of each installed module.
+const modulesFilename = "modules.json"
+
+type installedModule struct {
+	Version  string `json:"version"`
+	Location string `json:"location"`
+}
+
+func loadInstalledModules(wapcHome string) (map[string]installedModule, error) {
+	modules := map[string]installedModule{}
+	data, err := os.ReadFile(filepath.Join(wapcHome, modulesFilename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return modules, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &modules); err != nil {
+		return nil, err
+	}
+
+	return modules, nil
+}
+
+// recordInstalledModule stores the version of a module after it is installed.
+func recordInstalledModule(wapcHome, name string, module installedModule) error {
+	modules, err := loadInstalledModules(wapcHome)
+	if err != nil {
+		// Start over rather than fail the install on a corrupt manifest.
+		modules = map[string]installedModule{}
+	}
+	modules[name] = module
+
+	data, err := json.MarshalIndent(modules, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := stageFile(filepath.Join(wapcHome, modulesFilename), append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	_, err = file.commit()
+	return err
+}
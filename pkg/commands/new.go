@@ -47,7 +47,7 @@ func (c *NewCmd) Run(ctx *Context) error {
 		return fmt.Errorf("invalid template %s", c.Template)
 	}
 
-	homeDir, err := getHomeDirectory()
+	homeDir, err := getHomeDirectory(os.Stdout)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,144 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// UnmarshalYAML decodes the configuration and records the order in which
+// targets appear under generates.
+func (c *Config) UnmarshalYAML(value *yaml.Node) error {
+	type plain Config
+	if err := value.Decode((*plain)(c)); err != nil {
+		return err
+	}
+
+	c.order = nil
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		if value.Content[i].Value != "generates" {
+			continue
+		}
+		generates := value.Content[i+1]
+		for j := 0; j+1 < len(generates.Content); j += 2 {
+			c.order = append(c.order, generates.Content[j].Value)
+		}
+	}
+
+	return nil
+}
+
+// targetNames returns the keys of Generates in file order. Targets that were
+// not read from a file are appended in sorted order.
+func (c *Config) targetNames() []string {
+	names := make([]string, 0, len(c.Generates))
+	seen := make(map[string]struct{}, len(c.Generates))
+	for _, name := range c.order {
+		if _, ok := c.Generates[name]; !ok {
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		names = append(names, name)
+	}
+
+	var rest []string
+	for name := range c.Generates {
+		if _, ok := seen[name]; !ok {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+
+	return append(names, rest...)
+}
+
+// orderTargets returns the targets in the order they should run. Targets keep
+// their file order except that each one runs after the targets it depends on.
+func orderTargets(config *Config) ([]string, error) {
+	names := config.targetNames()
+	for _, name := range names {
+		for _, dependency := range config.Generates[name].DependsOn {
+			if _, ok := config.Generates[dependency]; !ok {
+				return nil, fmt.Errorf("%s depends on unknown target %s", name, dependency)
+			}
+		}
+	}
+
+	ordered := make([]string, 0, len(names))
+	done := make(map[string]bool, len(names))
+	for len(ordered) < len(names) {
+		progress := false
+		for _, name := range names {
+			if done[name] {
+				continue
+			}
+			ready := true
+			for _, dependency := range config.Generates[name].DependsOn {
+				if !done[dependency] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				done[name] = true
+				ordered = append(ordered, name)
+				progress = true
+				break
+			}
+		}
+
+		if !progress {
+			cycle := findCycle(config, names, done)
+			return nil, fmt.Errorf("dependency cycle between targets %s", strings.Join(cycle, ", "))
+		}
+	}
+
+	return ordered, nil
+}
+
+// findCycle returns the targets of a dependency cycle in file order. Every
+// target that is not done waits on another one that is not done, so
+// following those dependencies from any of them must end in a cycle.
+// Targets that only depend on the cycle are not part of it.
+func findCycle(config *Config, names []string, done map[string]bool) []string {
+	var path []string
+	visited := make(map[string]int)
+	name := ""
+	for _, n := range names {
+		if !done[n] {
+			name = n
+			break
+		}
+	}
+	for {
+		if start, ok := visited[name]; ok {
+			path = path[start:]
+			break
+		}
+		visited[name] = len(path)
+		path = append(path, name)
+		for _, dependency := range config.Generates[name].DependsOn {
+			if !done[dependency] {
+				name = dependency
+				break
+			}
+		}
+	}
+
+	inCycle := make(map[string]bool, len(path))
+	for _, n := range path {
+		inCycle[n] = true
+	}
+	cycle := make([]string, 0, len(path))
+	for _, n := range names {
+		if inCycle[n] {
+			cycle = append(cycle, n)
+		}
+	}
+	return cycle
+}
@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestOrderTargets(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+		err    string
+	}{
+		{
+			name: "file order",
+			config: `
+generates:
+  b: {}
+  a: {}
+  c: {}`,
+			want: []string{"b", "a", "c"},
+		},
+		{
+			name: "dependencies first",
+			config: `
+generates:
+  a: {dependsOn: [c]}
+  b: {}
+  c: {dependsOn: [b]}`,
+			want: []string{"b", "c", "a"},
+		},
+		{
+			name: "unknown dependency",
+			config: `
+generates:
+  a: {dependsOn: [missing]}`,
+			err: "a depends on unknown target missing",
+		},
+		{
+			name: "cycle",
+			config: `
+generates:
+  a: {dependsOn: [b]}
+  b: {dependsOn: [a]}
+  c: {}`,
+			err: "dependency cycle between targets a, b",
+		},
+		{
+			name: "self dependency",
+			config: `
+generates:
+  a: {dependsOn: [a]}`,
+			err: "dependency cycle between targets a",
+		},
+		{
+			name: "cycle behind a dependency",
+			config: `
+generates:
+  a: {dependsOn: [b]}
+  b: {dependsOn: [c]}
+  c: {dependsOn: [b]}
+  d: {}`,
+			err: "dependency cycle between targets b, c",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			if err := yaml.Unmarshal([]byte(tt.config), &config); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := orderTargets(&config)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("orderTargets() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("orderTargets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
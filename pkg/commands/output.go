@@ -0,0 +1,98 @@
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+)
+
+// stagedFile is generated output that has been written to a temporary file
+// next to its destination but has not yet replaced it.
+type stagedFile struct {
+	filename string
+	tempname string
+}
+
+// stageFile writes contents to a temporary file in the same directory as
+// filename. Keeping the file in the same directory (and with the same
+// extension) lets external formatters treat it like the real file and makes
+// the final rename atomic.
+func stageFile(filename string, contents []byte) (*stagedFile, error) {
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(dir, ".wapc-*-"+filepath.Base(filename))
+	if err != nil {
+		return nil, err
+	}
+	staged := &stagedFile{
+		filename: filename,
+		tempname: f.Name(),
+	}
+
+	if _, err = f.Write(contents); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		staged.discard()
+		return nil, err
+	}
+
+	return staged, nil
+}
+
+// commit replaces the destination file with the staged file. If the
+// destination already has identical contents it is left untouched so its
+// modification time does not change. commit reports whether the destination
+// was written.
+func (s *stagedFile) commit() (bool, error) {
+	contents, err := os.ReadFile(s.tempname)
+	if err != nil {
+		s.discard()
+		return false, err
+	}
+
+	mode := os.FileMode(0644)
+	info, err := os.Stat(s.filename)
+	if err != nil && !os.IsNotExist(err) {
+		s.discard()
+		return false, err
+	}
+	if err == nil {
+		existing, err := os.ReadFile(s.filename)
+		if err != nil {
+			s.discard()
+			return false, err
+		}
+		if bytes.Equal(existing, contents) {
+			s.discard()
+			return false, nil
+		}
+		mode = info.Mode().Perm()
+	}
+
+	if err = os.Chmod(s.tempname, mode); err != nil {
+		s.discard()
+		return false, err
+	}
+	if err = os.Rename(s.tempname, s.filename); err != nil {
+		s.discard()
+		return false, err
+	}
+	s.tempname = ""
+
+	return true, nil
+}
+
+// discard removes the staged file if it has not been committed.
+func (s *stagedFile) discard() {
+	if s.tempname != "" {
+		os.Remove(s.tempname)
+		s.tempname = ""
+	}
+}
@@ -0,0 +1,106 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStagedFileCommit(t *testing.T) {
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		existing string
+		exists   bool
+		contents string
+		written  bool
+	}{
+		{name: "new file", contents: "new", written: true},
+		{name: "changed file", existing: "old", exists: true, contents: "new", written: true},
+		{name: "unchanged file", existing: "same", exists: true, contents: "same"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filename := filepath.Join(dir, "module.rs")
+			if tt.exists {
+				if err := os.WriteFile(filename, []byte(tt.existing), 0600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(filename, old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			staged, err := stageFile(filename, []byte(tt.contents))
+			if err != nil {
+				t.Fatal(err)
+			}
+			written, err := staged.commit()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if written != tt.written {
+				t.Errorf("commit() = %v, want %v", written, tt.written)
+			}
+
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.contents {
+				t.Errorf("contents = %q, want %q", data, tt.contents)
+			}
+			info, err := os.Stat(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.exists && !tt.written && !info.ModTime().Equal(old) {
+				t.Errorf("modification time = %v, want %v", info.ModTime(), old)
+			}
+			if tt.exists && info.Mode().Perm() != 0600 {
+				t.Errorf("mode = %v, want the existing file's 0600", info.Mode().Perm())
+			}
+
+			// Only the destination is left behind.
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 || entries[0].Name() != "module.rs" {
+				t.Errorf("directory holds %v, want only module.rs", entries)
+			}
+		})
+	}
+}
+
+func TestStagedFileDiscard(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "module.rs")
+	if err := os.WriteFile(filename, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	staged, err := stageFile(filename, []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged.discard()
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "old" {
+		t.Errorf("contents = %q, want the untouched %q", data, "old")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %v, want only module.rs", entries)
+	}
+}
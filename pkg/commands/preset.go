@@ -0,0 +1,126 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// applyPreset expands the configuration's preset into its generates entries.
+// Presets are YAML files shipped by modules under
+// ~/.wapc/templates/<module>/presets/<name>.yaml. Entries in the
+// configuration override the preset's entries with the same filename.
+func (c *Config) applyPreset(homeDir string) error {
+	if c.Preset == "" {
+		return nil
+	}
+
+	filename, err := presetPath(homeDir, c.Preset)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("preset %q is not installed", c.Preset)
+		}
+		return err
+	}
+
+	var preset Config
+	if err = yaml.Unmarshal(data, &preset); err != nil {
+		return fmt.Errorf("invalid preset %q: %w", c.Preset, err)
+	}
+
+	// Preset config values are defaults for the configuration's values.
+	if len(preset.Config) > 0 && c.Config == nil {
+		c.Config = make(map[string]interface{}, len(preset.Config))
+	}
+	for k, v := range preset.Config {
+		if _, exists := c.Config[k]; !exists {
+			c.Config[k] = v
+		}
+	}
+
+	generates := make(map[string]Target, len(preset.Generates)+len(c.Generates))
+	for filename, target := range preset.Generates {
+		generates[filename] = target
+	}
+	for filename, target := range c.Generates {
+		if base, exists := generates[filename]; exists {
+			target = mergeTarget(base, target)
+		}
+		generates[filename] = target
+	}
+	c.Generates = generates
+	c.order = append(preset.order, c.order...)
+	c.presetFile = filename
+
+	return nil
+}
+
+// mergeTarget returns base with the fields set in override applied.
+func mergeTarget(base, override Target) Target {
+	if override.Module != "" {
+		base.Module = override.Module
+	}
+	// A visitor class and a generator function replace each other.
+	if override.VisitorClass != "" || override.Function != "" {
+		base.VisitorClass = override.VisitorClass
+		base.Function = override.Function
+	}
+	if override.IfNotExists != nil {
+		base.IfNotExists = override.IfNotExists
+	}
+	if override.Disabled != nil {
+		base.Disabled = override.Disabled
+	}
+	if len(override.Config) > 0 {
+		config := make(map[string]interface{}, len(base.Config)+len(override.Config))
+		for k, v := range base.Config {
+			config[k] = v
+		}
+		for k, v := range override.Config {
+			config[k] = v
+		}
+		base.Config = config
+	}
+	if len(override.Hooks.Pre) > 0 {
+		base.Hooks.Pre = override.Hooks.Pre
+	}
+	if len(override.Hooks.Post) > 0 {
+		base.Hooks.Post = override.Hooks.Post
+	}
+	base.DependsOn = append(base.DependsOn, override.DependsOn...)
+	if len(override.Stages) > 0 {
+		base.Stages = override.Stages
+	}
+	if override.Header != "" {
+		base.Header = override.Header
+	}
+	if override.Footer != "" {
+		base.Footer = override.Footer
+	}
+
+	return base
+}
+
+// presetPath returns the file for a preset named "<module>/<preset>", such as
+// "@wapc/widl-codegen/rust".
+func presetPath(homeDir, name string) (string, error) {
+	i := strings.LastIndex(name, "/")
+	if i <= 0 || i == len(name)-1 {
+		return "", fmt.Errorf("invalid preset %q: expected <module>/<preset>", name)
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.Contains(segment, `\`) {
+			return "", fmt.Errorf("invalid preset %q", name)
+		}
+	}
+
+	module, preset := name[:i], name[i+1:]
+	return filepath.Join(homeDir, "templates", filepath.FromSlash(module), "presets", preset+".yaml"), nil
+}
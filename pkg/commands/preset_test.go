@@ -0,0 +1,33 @@
+package commands
+
+import "testing"
+
+func TestMergeTargetFlags(t *testing.T) {
+	on, off := true, false
+	tests := []struct {
+		name     string
+		base     *bool
+		override *bool
+		want     bool
+	}{
+		{name: "unset keeps preset", base: &on, want: true},
+		{name: "false overrides preset", base: &on, override: &off, want: false},
+		{name: "true overrides preset", base: &off, override: &on, want: true},
+		{name: "unset everywhere", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := mergeTarget(
+				Target{IfNotExists: tt.base, Disabled: tt.base},
+				Target{IfNotExists: tt.override, Disabled: tt.override},
+			)
+			if got := isTrue(merged.IfNotExists); got != tt.want {
+				t.Errorf("IfNotExists = %v, want %v", got, tt.want)
+			}
+			if got := isTrue(merged.Disabled); got != tt.want {
+				t.Errorf("Disabled = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
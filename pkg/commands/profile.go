@@ -0,0 +1,101 @@
+package commands
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile overrides parts of a configuration when selected with --profile.
+type Profile struct {
+	// Config values are merged over the configuration's config.
+	Config map[string]interface{} `json:"config,omitempty" yaml:"config,omitempty"`
+	// Generates overrides existing targets by filename or adds new ones.
+	Generates map[string]TargetOverride `json:"generates,omitempty" yaml:"generates,omitempty"`
+}
+
+// TargetOverride replaces the non-empty fields of a target.
+type TargetOverride struct {
+	Module       string                 `json:"module,omitempty" yaml:"module,omitempty"`
+	VisitorClass string                 `json:"visitorClass,omitempty" yaml:"visitorClass,omitempty"`
+	Function     string                 `json:"function,omitempty" yaml:"function,omitempty"`
+	Disabled     *bool                  `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	Config       map[string]interface{} `json:"config,omitempty" yaml:"config,omitempty"`
+}
+
+// applyProfile applies the named profile to the configuration and removes
+// disabled targets. Configurations without profiles ignore the profile name;
+// checkProfile makes sure that another document in the file defines it.
+func (c *Config) applyProfile(name string) error {
+	if name != "" && len(c.Profiles) > 0 {
+		profile, ok := c.Profiles[name]
+		if !ok {
+			return fmt.Errorf("profile %q is not defined", name)
+		}
+
+		if len(profile.Config) > 0 && c.Config == nil {
+			c.Config = make(map[string]interface{}, len(profile.Config))
+		}
+		for k, v := range profile.Config {
+			c.Config[k] = v
+		}
+
+		if len(profile.Generates) > 0 && c.Generates == nil {
+			c.Generates = make(map[string]Target, len(profile.Generates))
+		}
+		for filename, override := range profile.Generates {
+			target := c.Generates[filename]
+			if override.Module != "" {
+				target.Module = override.Module
+			}
+			if override.VisitorClass != "" || override.Function != "" {
+				target.VisitorClass = override.VisitorClass
+				target.Function = override.Function
+			}
+			if override.Disabled != nil {
+				target.Disabled = override.Disabled
+			}
+			if len(override.Config) > 0 {
+				config := make(map[string]interface{}, len(target.Config)+len(override.Config))
+				for k, v := range target.Config {
+					config[k] = v
+				}
+				for k, v := range override.Config {
+					config[k] = v
+				}
+				target.Config = config
+			}
+			c.Generates[filename] = target
+		}
+	}
+
+	for filename, target := range c.Generates {
+		if isTrue(target.Disabled) {
+			delete(c.Generates, filename)
+		}
+	}
+
+	return nil
+}
+
+// checkProfile returns an error if a profile is selected but none of the
+// configuration's documents defines it, so that a misspelled profile does
+// not silently generate the default build.
+func checkProfile(documents []string, name string) error {
+	if name == "" {
+		return nil
+	}
+	for _, document := range documents {
+		var config struct {
+			Profiles map[string]yaml.Node `yaml:"profiles"`
+		}
+		if err := yaml.Unmarshal([]byte(document), &config); err != nil {
+			return err
+		}
+		if _, ok := config.Profiles[name]; ok {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("profile %q is not defined", name)
+}
@@ -0,0 +1,45 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckProfile(t *testing.T) {
+	withProfiles := `
+schema: a.widl
+profiles:
+  debug: {}
+  release: {}`
+	withoutProfiles := `
+schema: b.widl`
+
+	tests := []struct {
+		name      string
+		documents []string
+		profile   string
+		err       string
+	}{
+		{name: "no profile selected", documents: []string{withoutProfiles}},
+		{name: "defined", documents: []string{withProfiles}, profile: "release"},
+		{name: "defined by another document", documents: []string{withoutProfiles, withProfiles}, profile: "debug"},
+		{name: "misspelled", documents: []string{withProfiles}, profile: "relase", err: `profile "relase" is not defined`},
+		{name: "no profiles at all", documents: []string{withoutProfiles, ""}, profile: "release", err: `profile "release" is not defined`},
+		{name: "invalid document", documents: []string{"profiles: ["}, profile: "release", err: "yaml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkProfile(tt.documents, tt.profile)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("checkProfile() = %v, want error containing %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+)
+
+// Target statuses reported by `wapc generate --report`.
+const (
+	StatusWritten   = "written"
+	StatusUnchanged = "unchanged"
+	StatusSkipped   = "skipped"
+	StatusFailed    = "failed"
+)
+
+// GenerateReport is the machine-readable summary of a generate run.
+type GenerateReport struct {
+	Targets []*TargetResult `json:"targets"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// TargetResult describes the outcome of generating a single target.
+type TargetResult struct {
+	Filename     string         `json:"filename"`
+	Module       string         `json:"module"`
+	VisitorClass string         `json:"visitorClass"`
+	Function     string         `json:"function,omitempty"`
+	Status       string         `json:"status"`
+	Durations    PhaseDurations `json:"durations"`
+	Error        string         `json:"error,omitempty"`
+	// Files lists the files of a target whose generator returned several.
+	Files []string `json:"files,omitempty"`
+}
+
+// PhaseDurations holds the time spent in each generation phase in milliseconds.
+type PhaseDurations struct {
+	Bundle  float64 `json:"bundle"`
+	Compile float64 `json:"compile"`
+	Invoke  float64 `json:"invoke"`
+	Format  float64 `json:"format"`
+}
+
+func (r *GenerateReport) add(filename string, target Target) *TargetResult {
+	result := TargetResult{
+		Filename:     filename,
+		Module:       target.Module,
+		VisitorClass: target.VisitorClass,
+		Function:     target.Function,
+	}
+	// Composite targets list the modules, visitors and functions of each stage.
+	if len(target.Stages) > 0 {
+		modules := make([]string, len(target.Stages))
+		var visitors, functions []string
+		for i, stage := range target.Stages {
+			modules[i] = stage.Module
+			if stage.VisitorClass != "" {
+				visitors = append(visitors, stage.VisitorClass)
+			}
+			if stage.Function != "" {
+				functions = append(functions, stage.Function)
+			}
+		}
+		result.Module = strings.Join(modules, ", ")
+		result.VisitorClass = strings.Join(visitors, ", ")
+		result.Function = strings.Join(functions, ", ")
+	}
+	r.Targets = append(r.Targets, &result)
+	return &result
+}
+
+func (r *GenerateReport) writeJSON(w io.Writer) error {
+	if r.Targets == nil {
+		r.Targets = []*TargetResult{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// fail marks the target as failed and returns err so it can be used
+// directly in return statements.
+func (r *TargetResult) fail(err error) error {
+	r.Status = StatusFailed
+	r.Error = err.Error()
+	return err
+}
+
+// since returns the milliseconds elapsed since start.
+func since(start time.Time) float64 {
+	return float64(time.Since(start)) / float64(time.Millisecond)
+}
@@ -0,0 +1,98 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/tcnksm/go-input"
+	"golang.org/x/term"
+)
+
+// checkRequirements verifies that the installed modules satisfy the version
+// constraints in requires. Modules that do not are installed at the newest
+// matching version if --install-missing was given or the user agrees when
+// prompted.
+func (c *GenerateCmd) checkRequirements(homeDir string, requires map[string]string) error {
+	if len(requires) == 0 {
+		return nil
+	}
+
+	installed, err := loadInstalledModules(homeDir)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(requires))
+	for name := range requires {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		constraint, err := semver.NewConstraint(requires[name])
+		if err != nil {
+			return fmt.Errorf("invalid version constraint %q for %s: %w", requires[name], name, err)
+		}
+
+		var problem string
+		module, ok := installed[name]
+		if !ok {
+			problem = fmt.Sprintf("%s has no recorded version", name)
+		} else if version, err := semver.NewVersion(module.Version); err != nil {
+			problem = fmt.Sprintf("%s has an invalid version %q", name, module.Version)
+		} else if !constraint.Check(version) {
+			problem = fmt.Sprintf("%s %s does not satisfy %s", name, module.Version, requires[name])
+		} else {
+			continue
+		}
+
+		if !c.InstallMissing {
+			install, err := c.confirmInstall(problem)
+			if err != nil {
+				return err
+			}
+			if !install {
+				return fmt.Errorf("%s; use --install-missing to install a matching version", problem)
+			}
+		}
+
+		cmd := InstallCmd{
+			Location: name,
+			out:      c.out,
+		}
+		if cmd.Release, err = cmd.findNPMVersion(name, constraint); err != nil {
+			return err
+		}
+		if err = cmd.doRun(&Context{}, homeDir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// confirmInstall asks whether to install a module. It returns false without
+// asking when stdin is not a terminal.
+func (c *GenerateCmd) confirmInstall(problem string) (bool, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false, nil
+	}
+
+	ui := &input.UI{
+		Writer: c.out,
+		Reader: os.Stdin,
+	}
+	answer, err := ui.Ask(problem+". Install a matching version? [Y/n]", &input.Options{
+		Default:     "y",
+		HideDefault: true,
+		HideOrder:   true,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return strings.HasPrefix(strings.ToLower(answer), "y"), nil
+}
@@ -0,0 +1,94 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// resolveImport finds the WIDL file for an import location in the first of
+// roots that contains it. Locations must be relative, may not contain ".."
+// segments and may not resolve outside of their root, even through symlinks.
+func resolveImport(roots []string, location string) (string, error) {
+	if location == "" || strings.HasPrefix(location, "/") ||
+		strings.Contains(location, `\`) || filepath.IsAbs(location) ||
+		filepath.VolumeName(location) != "" {
+		return "", fmt.Errorf("invalid import %q: imports must be relative paths", location)
+	}
+	for _, segment := range strings.Split(location, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("invalid import %q: imports may not contain ..", location)
+		}
+	}
+
+	for _, root := range roots {
+		loc, found, err := findDefinition(root, location)
+		if err != nil {
+			return "", err
+		}
+		if found {
+			return loc, nil
+		}
+	}
+
+	return "", fmt.Errorf("could not find import %q in %s", location, strings.Join(roots, ", "))
+}
+
+// findDefinition looks for location inside root. It tries location with a
+// .widl extension and then an index.widl file in a directory of that name.
+func findDefinition(root, location string) (string, bool, error) {
+	loc := filepath.Join(root, filepath.FromSlash(location))
+	if filepath.Ext(loc) != ".widl" {
+		if stat, err := os.Stat(loc + ".widl"); err == nil && !stat.IsDir() {
+			loc += ".widl"
+		} else if stat, err := os.Stat(loc); err == nil && stat.IsDir() {
+			loc = filepath.Join(loc, "index.widl")
+		} else {
+			loc += ".widl"
+		}
+	}
+
+	stat, err := os.Stat(loc)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	if stat.IsDir() {
+		return "", false, nil
+	}
+
+	if err = confine(root, loc); err != nil {
+		return "", false, fmt.Errorf("import %q: %w", location, err)
+	}
+
+	return loc, true, nil
+}
+
+// confine returns an error if path, after following symlinks, is not inside
+// root.
+func confine(root, path string) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(realRoot, realPath)
+	if err != nil || isOutside(rel) {
+		return fmt.Errorf("%s is outside of the trusted directory %s", path, root)
+	}
+
+	return nil
+}
+
+// isOutside reports whether rel, a cleaned path relative to a directory,
+// names something outside of that directory. Absolute paths are outside.
+func isOutside(rel string) bool {
+	return filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
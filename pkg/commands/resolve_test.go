@@ -0,0 +1,138 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates files with the given contents under dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// symlink creates a symlink or skips the test where that is not permitted.
+func symlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks are not supported: %v", err)
+	}
+}
+
+func TestResolveImport(t *testing.T) {
+	base := t.TempDir()
+	first := filepath.Join(base, "first")
+	second := filepath.Join(base, "second")
+	outside := filepath.Join(base, "outside")
+	writeFiles(t, first, map[string]string{
+		"shared.widl":     "first",
+		"pkg/index.widl":  "index",
+		"types/foo.widl":  "foo",
+		"plain":           "no extension",
+		"dir.widl/x.widl": "directory named like a definition",
+	})
+	writeFiles(t, second, map[string]string{
+		"shared.widl": "second",
+		"only.widl":   "only",
+	})
+	writeFiles(t, outside, map[string]string{
+		"secret.widl": "secret",
+	})
+	symlink(t, filepath.Join(outside, "secret.widl"), filepath.Join(first, "escape.widl"))
+	symlink(t, outside, filepath.Join(first, "linked"))
+	symlink(t, filepath.Join(first, "types", "foo.widl"), filepath.Join(first, "alias.widl"))
+	roots := []string{first, second}
+
+	tests := []struct {
+		location string
+		want     string
+		err      string
+	}{
+		{location: "shared", want: filepath.Join(first, "shared.widl")},
+		{location: "shared.widl", want: filepath.Join(first, "shared.widl")},
+		{location: "only", want: filepath.Join(second, "only.widl")},
+		{location: "pkg", want: filepath.Join(first, "pkg", "index.widl")},
+		{location: "types/foo", want: filepath.Join(first, "types", "foo.widl")},
+		{location: "alias", want: filepath.Join(first, "alias.widl")},
+		{location: "missing", err: "could not find import"},
+		{location: "plain", err: "could not find import"},
+		{location: "dir.widl", err: "could not find import"},
+		{location: "", err: "must be relative paths"},
+		{location: "/etc/passwd", err: "must be relative paths"},
+		{location: filepath.Join(outside, "secret"), err: "must be relative paths"},
+		{location: `..\outside\secret`, err: "must be relative paths"},
+		{location: `types\foo`, err: "must be relative paths"},
+		{location: `C:\Windows\win.ini`, err: "must be relative paths"},
+		{location: "../outside/secret", err: "may not contain .."},
+		{location: "types/../../outside/secret", err: "may not contain .."},
+		{location: "..", err: "may not contain .."},
+		{location: "escape", err: "outside of the trusted directory"},
+		{location: "linked/secret", err: "outside of the trusted directory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			got, err := resolveImport(roots, tt.location)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("resolveImport(%q) = %q, %v, want error containing %q", tt.location, got, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveImport(%q) = %q, want %q", tt.location, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfineRootThroughSymlink(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "dir")
+	writeFiles(t, dir, map[string]string{"a.widl": "a", "sub/b.widl": "b"})
+	link := filepath.Join(base, "link")
+	symlink(t, dir, link)
+
+	// A root that is itself a symlink still contains its own files.
+	if err := confine(link, filepath.Join(link, "a.widl")); err != nil {
+		t.Errorf("confine() = %v, want nil", err)
+	}
+	err := confine(filepath.Join(dir, "sub"), filepath.Join(dir, "a.widl"))
+	if err == nil || !strings.Contains(err.Error(), "outside of the trusted directory") {
+		t.Errorf("confine() = %v for a file outside of root", err)
+	}
+}
+
+func TestIsOutside(t *testing.T) {
+	tests := []struct {
+		rel  string
+		want bool
+	}{
+		{rel: ".", want: false},
+		{rel: "a", want: false},
+		{rel: filepath.Join("a", "b"), want: false},
+		{rel: "..a", want: false},
+		{rel: "..", want: true},
+		{rel: filepath.Join("..", "a"), want: true},
+		{rel: string(filepath.Separator) + "a", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := isOutside(tt.rel); got != tt.want {
+			t.Errorf("isOutside(%q) = %v, want %v", tt.rel, got, tt.want)
+		}
+	}
+}
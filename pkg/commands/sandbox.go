@@ -0,0 +1,167 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wapc/cli/pkg/js"
+)
+
+// sandboxSource exposes the sandbox host functions to visitors as
+// wapc.readFile(path) and wapc.readDir(path).
+const sandboxSource = `function hostResult(result) {
+  if (result.startsWith("error: ")) {
+    throw new Error(result.substring(7));
+  }
+  return result;
+}
+
+globalThis.wapc = {
+  readFile(path) {
+    return hostResult(readFileCallback(path));
+  },
+  readDir(path) {
+    return JSON.parse(hostResult(readDirCallback(path)));
+  },
+};
+`
+
+// sandbox gives visitors read-only access to the project directory and the
+// templates directory of their module. Everything else is denied.
+type sandbox struct {
+	// projectDir is the directory relative paths are resolved against.
+	projectDir string
+	roots      []string
+	// reads are the files and directories the visitor accessed. They are
+	// inputs of the target like its WIDL imports.
+	reads []string
+}
+
+// dirEntry is an entry returned by wapc.readDir.
+type dirEntry struct {
+	Name  string `json:"name"`
+	IsDir bool   `json:"isDir"`
+}
+
+func newSandbox(projectDir, templatesDir string) *sandbox {
+	roots := []string{projectDir}
+	if templatesDir != "" {
+		roots = append(roots, templatesDir)
+	}
+
+	return &sandbox{
+		projectDir: projectDir,
+		roots:      roots,
+	}
+}
+
+// templatesDir returns the directory of a module's templates, or "" if the
+// module name could escape ~/.wapc/templates.
+func templatesDir(homeDir, module string) string {
+	if module == "" || strings.HasPrefix(module, "/") || strings.Contains(module, `\`) {
+		return ""
+	}
+	for _, segment := range strings.Split(module, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return ""
+		}
+	}
+
+	return filepath.Join(homeDir, "templates", filepath.FromSlash(module))
+}
+
+// resolve returns the path to access for a path requested by a visitor.
+// Relative paths are resolved against the project directory. A path inside
+// a root that does not exist is returned along with the error so that it
+// can still be recorded as an input.
+func (s *sandbox) resolve(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("invalid path %q", path)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.projectDir, filepath.FromSlash(path))
+	}
+	path = filepath.Clean(path)
+
+	for _, root := range s.roots {
+		rel, err := filepath.Rel(root, path)
+		if err != nil || isOutside(rel) {
+			continue
+		}
+		if err = confine(root, path); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return path, err
+			}
+			return "", err
+		}
+		return path, nil
+	}
+
+	return "", fmt.Errorf("access to %s is denied", path)
+}
+
+func (s *sandbox) readFile(path string) (string, error) {
+	path, err := s.resolve(path)
+	if path != "" {
+		s.reads = append(s.reads, path)
+	}
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+func (s *sandbox) readDir(path string) (string, error) {
+	path, err := s.resolve(path)
+	if path != "" {
+		s.reads = append(s.reads, path)
+	}
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+
+	list := make([]dirEntry, len(entries))
+	for i, entry := range entries {
+		list[i] = dirEntry{
+			Name:  entry.Name(),
+			IsDir: entry.IsDir(),
+		}
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// callbacks returns the host functions used by sandboxSource.
+func (s *sandbox) callbacks() map[string]js.Func {
+	return map[string]js.Func{
+		"readFileCallback": hostCallback(s.readFile),
+		"readDirCallback":  hostCallback(s.readDir),
+	}
+}
+
+// hostCallback adapts fn to a host function that takes a single string.
+func hostCallback(fn func(string) (string, error)) js.Func {
+	return func(args ...string) (string, error) {
+		if len(args) < 1 {
+			return "", errors.New("invalid arguments")
+		}
+		return fn(args[0])
+	}
+}
@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSandboxResolve(t *testing.T) {
+	base := t.TempDir()
+	project := filepath.Join(base, "project")
+	templates := filepath.Join(base, "templates", "module")
+	outside := filepath.Join(base, "outside")
+	writeFiles(t, project, map[string]string{"defs/a.widl": "a"})
+	writeFiles(t, templates, map[string]string{"main.hbs": "main"})
+	writeFiles(t, outside, map[string]string{"secret": "secret"})
+	symlink(t, filepath.Join(outside, "secret"), filepath.Join(project, "escape"))
+	symlink(t, outside, filepath.Join(project, "linked"))
+	symlink(t, filepath.Join(project, "defs", "a.widl"), filepath.Join(project, "alias"))
+	s := newSandbox(project, templates)
+
+	tests := []struct {
+		path string
+		want string
+		err  string
+	}{
+		{path: "defs/a.widl", want: filepath.Join(project, "defs", "a.widl")},
+		{path: "defs/../defs/a.widl", want: filepath.Join(project, "defs", "a.widl")},
+		{path: ".", want: project},
+		{path: "alias", want: filepath.Join(project, "alias")},
+		{path: "missing", want: filepath.Join(project, "missing"), err: "no such file"},
+		{path: filepath.Join(templates, "main.hbs"), want: filepath.Join(templates, "main.hbs")},
+		// Backslashes are not separators here, so this names a file in the
+		// project directory.
+		{path: `..\outside\secret`, want: filepath.Join(project, `..\outside\secret`), err: "no such file"},
+		{path: "", err: "invalid path"},
+		{path: "..", err: "is denied"},
+		{path: "../outside/secret", err: "is denied"},
+		{path: "defs/../../outside/secret", err: "is denied"},
+		{path: "/etc/passwd", err: "is denied"},
+		{path: filepath.Join(outside, "secret"), err: "is denied"},
+		{path: filepath.Join(templates, "..", "other"), err: "is denied"},
+		{path: "escape", err: "outside of the trusted directory"},
+		{path: "linked/secret", err: "outside of the trusted directory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := s.resolve(tt.path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("resolve(%q) = %q, %v, want error containing %q", tt.path, got, err, tt.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("resolve(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSandboxRecordsReads(t *testing.T) {
+	project := t.TempDir()
+	writeFiles(t, project, map[string]string{"defs/a.widl": "a"})
+	s := newSandbox(project, "")
+
+	if _, err := s.readFile("defs/a.widl"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.readDir("defs"); err != nil {
+		t.Fatal(err)
+	}
+	// Missing files are recorded so that creating them regenerates, but
+	// denied paths are not.
+	if _, err := s.readFile("missing"); err == nil {
+		t.Fatal("readFile(missing) succeeded")
+	}
+	if _, err := s.readFile("/etc/passwd"); err == nil {
+		t.Fatal("readFile(/etc/passwd) succeeded")
+	}
+
+	want := []string{
+		filepath.Join(project, "defs", "a.widl"),
+		filepath.Join(project, "defs"),
+		filepath.Join(project, "missing"),
+	}
+	if strings.Join(s.reads, "\n") != strings.Join(want, "\n") {
+		t.Errorf("reads = %q, want %q", s.reads, want)
+	}
+}
+
+func TestTemplatesDir(t *testing.T) {
+	tests := []struct {
+		module string
+		want   string
+	}{
+		{module: "@wapc/widl-codegen", want: filepath.Join("home", "templates", "@wapc", "widl-codegen")},
+		{module: "plain", want: filepath.Join("home", "templates", "plain")},
+		{module: ""},
+		{module: "/abs"},
+		{module: "../escape"},
+		{module: "a/../../escape"},
+		{module: "a//b"},
+		{module: `a\..\..\escape`},
+	}
+
+	for _, tt := range tests {
+		if got := templatesDir("home", tt.module); got != tt.want {
+			t.Errorf("templatesDir(%q) = %q, want %q", tt.module, got, tt.want)
+		}
+	}
+}
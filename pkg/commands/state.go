@@ -0,0 +1,234 @@
+package commands
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/wapc/cli/pkg/js"
+)
+
+// stateFilename is the file, stored next to the codegen configuration or in
+// the output directory, that records the inputs each target was last
+// generated from.
+const stateFilename = ".wapc-state.json"
+
+const stateVersion = 2
+
+type generateState struct {
+	Version int `json:"version"`
+	// Targets and the files of multi-file targets are keyed by their path
+	// relative to dir, so the working directory does not matter.
+	Targets map[string]targetState `json:"targets"`
+
+	// dir is the directory of the state file. The state of an empty dir is
+	// only kept in memory.
+	dir string
+}
+
+type targetState struct {
+	// Inputs is the hash of everything the output was generated from.
+	Inputs string `json:"inputs"`
+	// Imports are the WIDL files resolved while parsing the schema and the
+	// files and directories visitors read through the sandbox.
+	Imports []string `json:"imports,omitempty"`
+	// Output is the hash of the generated file after formatting.
+	Output string `json:"output,omitempty"`
+	// Files holds the hash of each file of a target whose generator returned
+	// several files.
+	Files map[string]string `json:"files,omitempty"`
+}
+
+// loadState reads the state file in dir. A missing or unreadable state file
+// is treated as empty so that every target is regenerated.
+func loadState(dir string) *generateState {
+	state := generateState{
+		Version: stateVersion,
+		Targets: map[string]targetState{},
+		dir:     dir,
+	}
+	if dir == "" {
+		return &state
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, stateFilename))
+	if err != nil {
+		return &state
+	}
+	var existing generateState
+	if err = json.Unmarshal(data, &existing); err != nil ||
+		existing.Version != stateVersion || existing.Targets == nil {
+		return &state
+	}
+	existing.dir = dir
+
+	return &existing
+}
+
+func (s *generateState) save() error {
+	if s.dir == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file, err := stageFile(filepath.Join(s.dir, stateFilename), append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	_, err = file.commit()
+	return err
+}
+
+// key returns the key of a generated file, which is its path relative to the
+// state file's directory.
+func (s *generateState) key(filename string) string {
+	dir, err := filepath.Abs(s.dir)
+	if err != nil {
+		return filepath.ToSlash(filename)
+	}
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return filepath.ToSlash(filename)
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// path returns the path of the generated file with the given key.
+func (s *generateState) path(key string) string {
+	name := filepath.FromSlash(key)
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(s.dir, name)
+}
+
+// target returns the state of the target at filename.
+func (s *generateState) target(filename string) targetState {
+	return s.Targets[s.key(filename)]
+}
+
+// setTarget records the state of the target at filename. files maps the
+// paths of a multi-file target's files to their hashes.
+func (s *generateState) setTarget(filename string, entry targetState, files map[string]string) {
+	for name, hash := range files {
+		if entry.Files == nil {
+			entry.Files = make(map[string]string, len(files))
+		}
+		entry.Files[s.key(name)] = hash
+	}
+	s.Targets[s.key(filename)] = entry
+}
+
+// upToDate reports whether filename was last generated from inputs with the
+// given hash and none of its files have been modified since.
+func (s *generateState) upToDate(filename, inputs string) bool {
+	entry, ok := s.Targets[s.key(filename)]
+	if !ok || entry.Inputs != inputs {
+		return false
+	}
+	if len(entry.Files) == 0 {
+		output, err := hashFile(filename)
+		return err == nil && output == entry.Output
+	}
+	for name, hash := range entry.Files {
+		output, err := hashFile(s.path(name))
+		if err != nil || output != hash {
+			return false
+		}
+	}
+	return true
+}
+
+// outputs returns the paths of the files that were generated for the target
+// at filename.
+func (s *generateState) outputs(filename string) []string {
+	entry := s.target(filename)
+	if len(entry.Files) == 0 {
+		return []string{filename}
+	}
+	names := make([]string, 0, len(entry.Files))
+	for name := range entry.Files {
+		names = append(names, s.path(name))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hashedContext returns the parts of a generation context that are hashed.
+// Paths are made relative to the state file's directory so that the working
+// directory does not matter, and Exists is left out because generating the
+// target changes it.
+func (s *generateState) hashedContext(generation GenerationContext) GenerationContext {
+	generation.Exists = false
+	generation.Filename = s.key(generation.Filename)
+	for _, path := range []*string{&generation.ConfigFile, &generation.Schema} {
+		if !isURL(*path) {
+			*path = s.key(*path)
+		}
+	}
+	return generation
+}
+
+// inputsHash hashes the inputs of a target: the CLI version, the schema and
+// its resolved imports, the merged target configuration, the generation
+// context and environment given to visitors and the module bundle.
+func inputsHash(version, schema string, target Target, generation GenerationContext, bundle string, imports []string) (string, error) {
+	targetJSON, err := json.Marshal(target)
+	if err != nil {
+		return "", err
+	}
+	generationJSON, err := json.Marshal(generation)
+	if err != nil {
+		return "", err
+	}
+	envJSON, err := json.Marshal(js.Env())
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	for _, part := range []string{version, schema, string(targetJSON), string(generationJSON), string(envJSON), bundle} {
+		io.WriteString(h, part)
+		h.Write([]byte{0})
+	}
+	for _, imp := range imports {
+		io.WriteString(h, imp)
+		h.Write([]byte{0})
+		data, err := os.ReadFile(imp)
+		if err == nil {
+			h.Write(data)
+		} else if entries, dirErr := os.ReadDir(imp); dirErr == nil {
+			// Directories read by visitors are hashed by their listing.
+			for _, entry := range entries {
+				io.WriteString(h, entry.Name())
+				h.Write([]byte{0})
+			}
+		} else {
+			// A missing import changes the hash and forces regeneration.
+			io.WriteString(h, "missing")
+		}
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(filename string) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
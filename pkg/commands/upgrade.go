@@ -1,5 +1,7 @@
 package commands
 
+import "os"
+
 type UpgradeCmd struct {
 }
 
@@ -9,5 +11,5 @@ func (c *UpgradeCmd) Run(ctx *Context) error {
 		return err
 	}
 
-	return checkDependencies(wapcHome, true)
+	return checkDependencies(os.Stdout, wapcHome, true)
 }
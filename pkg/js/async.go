@@ -0,0 +1,51 @@
+package js
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeout bounds how long an invocation, including its timers and
+// promises, may run.
+const DefaultTimeout = time.Minute
+
+// maxTimers caps the number of timer callbacks run after each invocation.
+const maxTimers = 10000
+
+// ErrTimeout is returned when an invocation runs longer than its timeout.
+var ErrTimeout = errors.New("script timed out")
+
+// withTimeout runs fn and calls interrupt if it is still running when the
+// timeout elapses.
+func withTimeout(timeout time.Duration, interrupt func(), fn func() error) error {
+	if timeout <= 0 {
+		return fn()
+	}
+
+	var timedOut int32
+	timer := time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&timedOut, 1)
+		interrupt()
+	})
+	err := fn()
+	timer.Stop()
+	if atomic.LoadInt32(&timedOut) == 1 {
+		return fmt.Errorf("%w after %s", ErrTimeout, timeout)
+	}
+
+	return err
+}
+
+// runTimers calls runTimer, which runs pending microtasks and the next due
+// timer, until it reports that no timers are left.
+func runTimers(runTimer func() (bool, error)) error {
+	for i := 0; i < maxTimers; i++ {
+		ran, err := runTimer()
+		if err != nil || !ran {
+			return err
+		}
+	}
+	return errors.New("too many timers; is a setInterval never cleared?")
+}
@@ -0,0 +1,88 @@
+package js
+
+import (
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Level is the severity of a console message.
+type Level int
+
+const (
+	LevelTrace Level = iota
+	LevelDebug
+	LevelInfo
+	LevelWarn
+	LevelError
+	// LevelSilent discards all messages.
+	LevelSilent
+)
+
+var levelNames = []string{"trace", "debug", "info", "warn", "error", "silent"}
+
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level with the given name.
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// Console is where messages logged by scripts are written.
+type Console struct {
+	Out io.Writer
+	// Level is the lowest level that is written.
+	Level Level
+	// Prefix tags every message, e.g. with the target being generated.
+	Prefix string
+}
+
+// DefaultConsole is used by scripts compiled without a console.
+var DefaultConsole = &Console{
+	Out:   os.Stderr,
+	Level: LevelInfo,
+}
+
+//go:embed console.js
+var consoleSource string
+
+// write logs a message at level if the console's level allows it.
+func (c *Console) write(level Level, message string) {
+	if level < c.Level {
+		return
+	}
+
+	var b strings.Builder
+	if c.Prefix != "" {
+		fmt.Fprintf(&b, "[%s] ", c.Prefix)
+	}
+	if level != LevelInfo {
+		fmt.Fprintf(&b, "%s: ", level)
+	}
+	b.WriteString(message)
+	b.WriteString("\n")
+	io.WriteString(c.Out, b.String())
+}
+
+// log is the host function that consoleSource writes messages with.
+func (c *Console) log(args ...string) (string, error) {
+	if len(args) == 2 {
+		if level, err := strconv.Atoi(args[0]); err == nil {
+			c.write(Level(level), args[1])
+		}
+	}
+	return "", nil
+}
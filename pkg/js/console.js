@@ -0,0 +1,64 @@
+// console and println. Values are formatted here and written by the host,
+// which filters them by level.
+(function (global) {
+  "use strict";
+
+  const levels = { trace: 0, debug: 1, log: 2, info: 2, warn: 3, error: 4 };
+
+  function format(value) {
+    switch (typeof value) {
+      case "string":
+        return value;
+      case "undefined":
+        return "undefined";
+      case "function":
+        return value.name ? `[Function: ${value.name}]` : "[Function]";
+      case "symbol":
+      case "bigint":
+        return value.toString();
+    }
+    if (value instanceof Error) {
+      // Some engines end stacks with a newline.
+      return (value.stack || String(value)).trimEnd();
+    }
+
+    // Objects are written as JSON with cycles replaced.
+    const ancestors = [];
+    try {
+      return JSON.stringify(value, function (key, v) {
+        if (typeof v === "bigint") {
+          return v.toString();
+        }
+        if (typeof v !== "object" || v === null) {
+          return v;
+        }
+        // `this` is the object that contains key.
+        while (ancestors.length > 0 && ancestors[ancestors.length - 1] !== this) {
+          ancestors.pop();
+        }
+        if (ancestors.includes(v)) {
+          return "[Circular]";
+        }
+        ancestors.push(v);
+        return v;
+      });
+    } catch (e) {
+      return String(value);
+    }
+  }
+
+  const console = {};
+  for (const method of Object.keys(levels)) {
+    console[method] = function (...args) {
+      let message = args.map(format).join(" ");
+      if (method === "trace") {
+        const stack = new Error().stack.split("\n").slice(2).join("\n").trimEnd();
+        message = message ? `${message}\n${stack}` : stack;
+      }
+      __host_log(levels[method], message);
+    };
+  }
+
+  global.console = console;
+  global.println = console.log;
+})(globalThis);
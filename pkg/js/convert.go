@@ -0,0 +1,75 @@
+package js
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+)
+
+// convertSource defines the function that serializes objects returned to Go.
+// BigInts, which JSON.stringify rejects, are written as strings.
+//
+// Values returned by scripts are converted to Go values as follows:
+//
+//	null, undefined   nil
+//	string            string
+//	boolean           bool
+//	int32 numbers     int32
+//	other numbers     float64
+//	BigInt            decimal string (v8 only; goja has no BigInt)
+//	arrays            []interface{}
+//	objects           map[string]interface{}
+//
+// Arrays and objects are converted through JSON, and their elements follow
+// the same rules, except that undefined and functions are dropped from
+// objects and become null in arrays as with JSON.stringify. Symbols and
+// functions returned directly cannot be converted.
+const convertSource = `function js_toJSON(value) {
+  return JSON.stringify(value, (key, v) => (typeof v === "bigint" ? v.toString() : v));
+}`
+
+// number converts a number to int32 if it is one and to float64 otherwise.
+func number(f float64) interface{} {
+	if f == math.Trunc(f) && f >= math.MinInt32 && f <= math.MaxInt32 && !(f == 0 && math.Signbit(f)) {
+		return int32(f)
+	}
+	return f
+}
+
+// fromJSON decodes a value serialized by js_toJSON. Numbers are converted
+// with number so that they match numbers returned directly.
+func fromJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var result interface{}
+	if err := decoder.Decode(&result); err != nil {
+		return nil, err
+	}
+	return numbers(result)
+}
+
+// numbers replaces the json.Numbers in a decoded value.
+func numbers(value interface{}) (interface{}, error) {
+	var err error
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return number(f), nil
+	case []interface{}:
+		for i := range v {
+			if v[i], err = numbers(v[i]); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		for k := range v {
+			if v[k], err = numbers(v[k]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,278 @@
+package js
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+func init() {
+	engines["goja"] = compileGoja
+}
+
+// gojaJS runs scripts with goja, a JavaScript interpreter written in Go. It
+// is slower than V8 but needs no cgo.
+type gojaJS struct {
+	vm      *goja.Runtime
+	timeout time.Duration
+}
+
+func compileGoja(source string, globals map[string]Func) (JS, error) {
+	vm := goja.New()
+	for name, fn := range globals {
+		if err := vm.Set(name, gojaFunc(vm, fn)); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range preludes() {
+		if _, err := vm.RunScript(s.name, s.source); err != nil {
+			return nil, gojaError(err)
+		}
+	}
+
+	js := gojaJS{
+		vm:      vm,
+		timeout: DefaultTimeout,
+	}
+	_, err := js.run(func() (goja.Value, error) {
+		return vm.RunScript("bundle.js", source)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &js, nil
+}
+
+// gojaFunc adapts a host function to goja.
+func gojaFunc(vm *goja.Runtime, fn Func) func(goja.FunctionCall) goja.Value {
+	return func(call goja.FunctionCall) goja.Value {
+		args := make([]string, len(call.Arguments))
+		for i, arg := range call.Arguments {
+			args[i] = arg.String()
+		}
+		return vm.ToValue(fn.call(args))
+	}
+}
+
+// gojaError converts exceptions thrown by scripts to *Error.
+func gojaError(err error) error {
+	var exception *goja.Exception
+	if !errors.As(err, &exception) {
+		return err
+	}
+	jserr := Error{
+		Message:    exception.Value().String(),
+		StackTrace: exception.String(),
+	}
+	if object, ok := exception.Value().(*goja.Object); ok {
+		if stack := object.Get("stack"); stack != nil && !goja.IsUndefined(stack) {
+			jserr.StackTrace = stack.String()
+		}
+	}
+	return &jserr
+}
+
+// Dispose is a no-op; the runtime is garbage collected.
+func (js *gojaJS) Dispose() {}
+
+func (js *gojaJS) SetTimeout(timeout time.Duration) {
+	js.timeout = timeout
+}
+
+func (js *gojaJS) Invoke(function string, args ...interface{}) (interface{}, error) {
+	res, err := js.invoke(function, args...)
+	if err != nil {
+		return nil, err
+	}
+	return js.toGo(res)
+}
+
+func (js *gojaJS) InvokeInto(out interface{}, function string, args ...interface{}) error {
+	res, err := js.invoke(function, args...)
+	if err != nil {
+		return err
+	}
+	data, err := js.toJSON(res)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (js *gojaJS) invoke(function string, args ...interface{}) (goja.Value, error) {
+	fn, err := js.export(function)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]goja.Value, len(args))
+	for i, arg := range args {
+		if values[i], err = js.convertInterface(arg); err != nil {
+			return nil, err
+		}
+	}
+
+	// Timers and promises started by the function are settled once it
+	// returns.
+	return js.run(func() (goja.Value, error) {
+		res, err := fn(goja.Undefined(), values...)
+		if err != nil {
+			return nil, err
+		}
+		return js.settle(res)
+	})
+}
+
+// export returns the exported function with the given name.
+func (js *gojaJS) export(name string) (goja.Callable, error) {
+	exports := js.vm.Get("js_exports").ToObject(js.vm)
+	value := exports.Get(name)
+	if value == nil {
+		return nil, &NoSuchExportError{Name: name}
+	}
+	fn, ok := goja.AssertFunction(value)
+	if !ok {
+		return nil, &NoSuchExportError{Name: name}
+	}
+
+	return fn, nil
+}
+
+func (js *gojaJS) convertInterface(value interface{}) (goja.Value, error) {
+	jsonBytes, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	parse, ok := goja.AssertFunction(js.vm.Get("JSON").ToObject(js.vm).Get("parse"))
+	if !ok {
+		return nil, errors.New("JSON.parse is not a function")
+	}
+	return parse(goja.Undefined(), js.vm.ToValue(string(jsonBytes)))
+}
+
+// run runs fn and interrupts the script if it is still running when the
+// timeout elapses.
+func (js *gojaJS) run(fn func() (goja.Value, error)) (goja.Value, error) {
+	var value goja.Value
+	interrupt := func() {
+		js.vm.Interrupt(ErrTimeout)
+	}
+	err := withTimeout(js.timeout, interrupt, func() (err error) {
+		value, err = fn()
+		return gojaError(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// settle runs pending timers until none are left; goja runs microtasks
+// whenever control returns to Go. If value is a promise, its result is
+// returned once it is fulfilled and its rejection reason is returned as an
+// error.
+func (js *gojaJS) settle(value goja.Value) (goja.Value, error) {
+	runTimer, err := js.global("__runTimer")
+	if err != nil {
+		return nil, err
+	}
+	err = runTimers(func() (bool, error) {
+		ran, err := runTimer(goja.Undefined())
+		if err != nil {
+			return false, err
+		}
+		return ran.ToBoolean(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	promise, ok := value.Export().(*goja.Promise)
+	if !ok {
+		return value, nil
+	}
+	switch promise.State() {
+	case goja.PromiseStateFulfilled:
+		return promise.Result(), nil
+	case goja.PromiseStateRejected:
+		return nil, js.rejection(promise.Result())
+	}
+
+	return nil, errors.New("promise never settled")
+}
+
+// rejection converts a promise's rejection reason to an error. Reasons other
+// than errors are described as JSON where possible.
+func (js *gojaJS) rejection(reason goja.Value) error {
+	jserr := Error{
+		Message: reason.String(),
+	}
+	if object, ok := reason.(*goja.Object); ok {
+		if object.ClassName() == "Error" {
+			if stack := object.Get("stack"); stack != nil && !goja.IsUndefined(stack) {
+				jserr.StackTrace = stack.String()
+			}
+		} else if data, err := js.toJSON(reason); err == nil {
+			jserr.Message = string(data)
+		}
+	}
+
+	return &jserr
+}
+
+// toGo converts a value returned by a script as described by convertSource.
+func (js *gojaJS) toGo(value goja.Value) (interface{}, error) {
+	if value == nil || goja.IsUndefined(value) || goja.IsNull(value) {
+		return nil, nil
+	}
+	if _, ok := value.(*goja.Symbol); ok {
+		return nil, fmt.Errorf("cannot convert %s to a Go value", value)
+	}
+	if _, ok := goja.AssertFunction(value); ok {
+		return nil, fmt.Errorf("cannot convert %s to a Go value", value)
+	}
+	switch v := value.Export().(type) {
+	case string, bool:
+		return v, nil
+	case int64:
+		return number(float64(v)), nil
+	case float64:
+		return number(v), nil
+	}
+
+	data, err := js.toJSON(value)
+	if err != nil {
+		return nil, err
+	}
+	return fromJSON(data)
+}
+
+// toJSON serializes a value with JSON.stringify.
+func (js *gojaJS) toJSON(value goja.Value) ([]byte, error) {
+	fn, err := js.global("js_toJSON")
+	if err != nil {
+		return nil, err
+	}
+	result, err := fn(goja.Undefined(), value)
+	if err != nil {
+		return nil, gojaError(err)
+	}
+	if goja.IsUndefined(result) {
+		return nil, fmt.Errorf("cannot convert %s to JSON", value)
+	}
+
+	return []byte(result.String()), nil
+}
+
+// global returns the global function with the given name.
+func (js *gojaJS) global(name string) (goja.Callable, error) {
+	fn, ok := goja.AssertFunction(js.vm.Get(name))
+	if !ok {
+		return nil, errors.New(name + " is not a function")
+	}
+	return fn, nil
+}
@@ -1,117 +1,131 @@
 package js
 
 import (
-	"encoding/json"
 	"fmt"
-	"strings"
-
-	"rogchap.com/v8go"
+	"sort"
+	"time"
 )
 
-type JS struct {
-	iso *v8go.Isolate
-	ctx *v8go.Context
+// JS is a compiled script whose exported functions can be invoked.
+type JS interface {
+	// Invoke calls an exported function and converts its result to a Go
+	// value as described by convertSource.
+	Invoke(function string, args ...interface{}) (interface{}, error)
+	// InvokeInto calls an exported function and decodes its result into out
+	// with encoding/json.
+	InvokeInto(out interface{}, function string, args ...interface{}) error
+	// SetTimeout changes the timeout of later invocations. Zero disables it.
+	SetTimeout(timeout time.Duration)
+	Dispose()
 }
 
-func Compile(source string, globals ...map[string]v8go.FunctionCallback) (*JS, error) {
-	iso, err := v8go.NewIsolate()
-	if err != nil {
-		return nil, err
+// Func is a host function that scripts can call. Its arguments are converted
+// to strings. An error is returned to the script as a string prefixed with
+// "error: ".
+type Func func(args ...string) (string, error)
+
+// Error is an exception thrown by a script.
+type Error struct {
+	Message    string
+	StackTrace string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// NoSuchExportError is returned when invoking a name that the script does not
+// export as a function.
+type NoSuchExportError struct {
+	Name string
+}
+
+func (e *NoSuchExportError) Error() string {
+	return fmt.Sprintf("no such export: %s is not an exported function", e.Name)
+}
+
+// compileFunc compiles a script with one of the engines.
+type compileFunc func(source string, globals map[string]Func) (JS, error)
+
+// engines holds the available engines by name. The v8 engine is only
+// available in builds with cgo.
+var engines = map[string]compileFunc{}
+
+// engine is the name of the engine selected with SetEngine.
+var engine string
+
+// Engines returns the names of the available engines.
+func Engines() []string {
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
 	}
-	global, err := v8go.NewObjectTemplate(iso)
-	if err != nil {
-		return nil, err
+	sort.Strings(names)
+	return names
+}
+
+// SetEngine selects the engine that Compile uses. An empty name selects v8
+// if it is available and goja otherwise.
+func SetEngine(name string) error {
+	if _, ok := engines[name]; name != "" && !ok {
+		return fmt.Errorf("unknown JavaScript engine %q (available: %v)", name, Engines())
 	}
-	console, err := v8go.NewObjectTemplate(iso)
-	if err != nil {
-		return nil, err
+	engine = name
+	return nil
+}
+
+// Compile runs source with the selected engine. Messages logged by the
+// script are written to console, or DefaultConsole if it is nil.
+func Compile(source string, console *Console, globals ...map[string]Func) (JS, error) {
+	if console == nil {
+		console = DefaultConsole
 	}
-	log, err := v8go.NewFunctionTemplate(iso, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
-		args := make([]interface{}, len(info.Args()))
-		for i, a := range info.Args() {
-			args[i] = a
-		}
-		fmt.Println(args...)
-		return nil
-	})
-	if err != nil {
-		return nil, err
+
+	all := map[string]Func{
+		"__host_log": console.log,
+	}
+	for name, fn := range nodeFuncs() {
+		all[name] = fn
 	}
-	console.Set("log", log)
-	global.Set("println", log)
 	for _, g := range globals {
-		for name, callback := range g {
-			funcTemp, err := v8go.NewFunctionTemplate(iso, callback)
-			if err != nil {
-				return nil, err
-			}
-			global.Set(name, funcTemp)
+		for name, fn := range g {
+			all[name] = fn
 		}
 	}
-	ctx, err := v8go.NewContext(iso, global)
-	if err != nil {
-		return nil, err
-	}
-	consoleObject, err := console.NewInstance(ctx)
-	if err != nil {
-		return nil, err
-	}
-	ctx.Global().Set("console", consoleObject)
-	_, err = ctx.RunScript(`var js_exports = {};`, "exports.js")
-	if err != nil {
-		return nil, err
-	}
-	_, err = ctx.RunScript(source, "bundle.js")
-	if err != nil {
-		return nil, err
-	}
 
-	return &JS{
-		iso: iso,
-		ctx: ctx,
-	}, nil
-}
-
-func (js *JS) Dispose() {
-	js.ctx.Close()
-	js.iso.Dispose()
-}
-
-func (js *JS) Invoke(function string, args ...interface{}) (interface{}, error) {
-	global := js.ctx.Global()
-	var argList strings.Builder
-
-	for i, arg := range args {
-		argName := fmt.Sprintf("arg_%d", i)
-		value, err := js.convertInterface(arg)
-		if err != nil {
-			return nil, err
-		}
-		global.Set(argName, value)
-		if i > 0 {
-			argList.WriteString(", ")
+	name := engine
+	if name == "" {
+		name = "goja"
+		if _, ok := engines["v8"]; ok {
+			name = "v8"
 		}
-		argList.WriteString(argName)
 	}
+	return engines[name](source, all)
+}
 
-	res, err := js.ctx.RunScript(`js_exports.`+function+`(`+argList.String()+`);`, "test.js")
-	if err != nil {
-		return nil, err
-	}
+// script is a named script run before the bundle.
+type script struct {
+	name   string
+	source string
+}
 
-	if res.IsString() {
-		return res.String(), nil
-	} else if res.IsInt32() {
-		return res.Int32(), nil
+// preludes returns the scripts that set up the globals shared by all engines.
+func preludes() []script {
+	return []script{
+		{"console.js", consoleSource},
+		{"node.js", nodeSource},
+		{"convert.js", convertSource},
+		// Exports have no prototype so that inherited members such as
+		// toString cannot be invoked.
+		{"exports.js", `var js_exports = Object.create(null);`},
 	}
-
-	return res, err
 }
 
-func (js *JS) convertInterface(value interface{}) (*v8go.Value, error) {
-	jsonBytes, err := json.Marshal(value)
+// call runs a host function and returns its result or error to the script.
+func (fn Func) call(args []string) string {
+	result, err := fn(args...)
 	if err != nil {
-		return nil, err
+		return "error: " + err.Error()
 	}
-	return v8go.JSONParse(js.ctx, string(jsonBytes))
+	return result
 }
@@ -0,0 +1,315 @@
+package js
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withEngines runs test once with each available engine. The subtest is
+// named after the engine.
+func withEngines(t *testing.T, test func(t *testing.T)) {
+	for _, name := range Engines() {
+		t.Run(name, func(t *testing.T) {
+			if err := SetEngine(name); err != nil {
+				t.Fatal(err)
+			}
+			defer SetEngine("")
+			test(t)
+		})
+	}
+}
+
+// engineName returns the engine a subtest of withEngines runs with.
+func engineName(t *testing.T) string {
+	name := t.Name()
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+func TestInvokeExports(t *testing.T) {
+	source := `js_exports.greet = function (name) { return "Hello, " + name; };
+js_exports.notFunction = 42;
+js_exports.nothing = undefined;`
+
+	tests := []struct {
+		function string
+		want     interface{}
+		missing  bool
+	}{
+		{function: "greet", want: "Hello, world"},
+		{function: "missing", missing: true},
+		{function: "notFunction", missing: true},
+		{function: "nothing", missing: true},
+		{function: "toString", missing: true},
+		{function: "constructor", missing: true},
+		{function: "__proto__", missing: true},
+		{function: "hasOwnProperty", missing: true},
+	}
+
+	withEngines(t, func(t *testing.T) {
+		vm, err := Compile(source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer vm.Dispose()
+
+		for _, tt := range tests {
+			got, err := vm.Invoke(tt.function, "world")
+			if tt.missing {
+				var noSuchExport *NoSuchExportError
+				if !errors.As(err, &noSuchExport) || noSuchExport.Name != tt.function {
+					t.Errorf("Invoke(%q) = %v, %v, want a NoSuchExportError", tt.function, got, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("Invoke(%q) error = %v", tt.function, err)
+			} else if got != tt.want {
+				t.Errorf("Invoke(%q) = %v, want %v", tt.function, got, tt.want)
+			}
+		}
+	})
+}
+
+func TestProcessEnv(t *testing.T) {
+	t.Setenv("NODE_ENV", "production")
+	t.Setenv("WAPC_PROFILE", "release")
+	t.Setenv("WAPC_TOKEN", "secret")
+	t.Setenv("HOME_SECRET", "secret")
+	source := `js_exports.env = function (name) { return process.env[name] === undefined ? null : process.env[name]; };`
+
+	tests := []struct {
+		name string
+		want interface{}
+	}{
+		{name: "NODE_ENV", want: "production"},
+		{name: "WAPC_PROFILE", want: "release"},
+		{name: "WAPC_TOKEN", want: nil},
+		{name: "HOME_SECRET", want: nil},
+	}
+
+	withEngines(t, func(t *testing.T) {
+		vm, err := Compile(source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer vm.Dispose()
+
+		for _, tt := range tests {
+			got, err := vm.Invoke("env", tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("process.env.%s = %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	})
+}
+
+func TestInvokeConversions(t *testing.T) {
+	source := `js_exports.value = function (name) {
+  return {
+    null: null,
+    undefined: undefined,
+    string: "text",
+    true: true,
+    false: false,
+    int: 42,
+    negative: -7,
+    float: 1.5,
+    large: 2 ** 40,
+    array: [1, "a", true, null, 1.5],
+    object: { a: 1, b: { c: [2, 2.5] }, s: "x", skipped: undefined },
+    empty: {},
+    bigint: typeof BigInt === "function" ? BigInt("12345678901234567890") : undefined,
+    nestedBigint: typeof BigInt === "function" ? { n: BigInt(7) } : undefined,
+    fn: function () {},
+    symbol: Symbol("s"),
+  }[name];
+};`
+
+	tests := []struct {
+		name   string
+		want   interface{}
+		err    string
+		v8Only bool
+	}{
+		{name: "null", want: nil},
+		{name: "undefined", want: nil},
+		{name: "string", want: "text"},
+		{name: "true", want: true},
+		{name: "false", want: false},
+		{name: "int", want: int32(42)},
+		{name: "negative", want: int32(-7)},
+		{name: "float", want: 1.5},
+		{name: "large", want: float64(1 << 40)},
+		{name: "array", want: []interface{}{int32(1), "a", true, nil, 1.5}},
+		{name: "object", want: map[string]interface{}{
+			"a": int32(1),
+			"b": map[string]interface{}{"c": []interface{}{int32(2), 2.5}},
+			"s": "x",
+		}},
+		{name: "empty", want: map[string]interface{}{}},
+		{name: "bigint", want: "12345678901234567890", v8Only: true},
+		{name: "nestedBigint", want: map[string]interface{}{"n": "7"}, v8Only: true},
+		{name: "fn", err: "cannot convert"},
+		{name: "symbol", err: "cannot convert"},
+	}
+
+	withEngines(t, func(t *testing.T) {
+		vm, err := Compile(source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer vm.Dispose()
+
+		for _, tt := range tests {
+			if tt.v8Only && engineName(t) != "v8" {
+				continue
+			}
+			got, err := vm.Invoke("value", tt.name)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("%s: Invoke() = %#v, %v, want error containing %q", tt.name, got, err, tt.err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s: Invoke() error = %v", tt.name, err)
+			} else if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: Invoke() = %#v, want %#v", tt.name, got, tt.want)
+			}
+		}
+	})
+}
+
+func TestInvokeInto(t *testing.T) {
+	source := `js_exports.files = function (prefix) {
+  return { count: 2, names: [prefix + ".rs", prefix + ".go"], nested: { ok: true } };
+};`
+	type files struct {
+		Count  int      `json:"count"`
+		Names  []string `json:"names"`
+		Nested struct {
+			OK bool `json:"ok"`
+		} `json:"nested"`
+	}
+
+	withEngines(t, func(t *testing.T) {
+		vm, err := Compile(source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer vm.Dispose()
+
+		var got files
+		if err = vm.InvokeInto(&got, "files", "module"); err != nil {
+			t.Fatal(err)
+		}
+		if got.Count != 2 || !reflect.DeepEqual(got.Names, []string{"module.rs", "module.go"}) || !got.Nested.OK {
+			t.Errorf("InvokeInto() = %+v", got)
+		}
+	})
+}
+
+func TestInvokeSettles(t *testing.T) {
+	source := `js_exports.resolved = function () {
+  return Promise.resolve({ value: 42 });
+};
+js_exports.awaited = async function (delay) {
+  await new Promise((resolve) => setTimeout(resolve, Number(delay)));
+  return "done";
+};
+js_exports.rejected = async function () {
+  throw new Error("boom");
+};
+js_exports.rejectedValue = function () {
+  return Promise.reject({ code: 1 });
+};
+js_exports.never = function () {
+  return new Promise(() => {});
+};
+js_exports.timers = function () {
+  const order = [];
+  setTimeout(() => order.push("late"), 20);
+  setTimeout(() => order.push("early"), 10);
+  const id = setTimeout(() => order.push("cleared"), 5);
+  clearTimeout(id);
+  Promise.resolve().then(() => order.push("microtask"));
+  order.push("sync");
+  return new Promise((resolve) => setTimeout(() => resolve(order), 30));
+};
+js_exports.interval = function () {
+  setInterval(() => {}, 1);
+};`
+
+	tests := []struct {
+		function string
+		want     interface{}
+		err      string
+	}{
+		{function: "resolved", want: map[string]interface{}{"value": int32(42)}},
+		{function: "awaited", want: "done"},
+		{function: "rejected", err: "boom"},
+		{function: "rejectedValue", err: `{"code":1}`},
+		{function: "never", err: "promise never settled"},
+		{function: "timers", want: []interface{}{"sync", "microtask", "early", "late"}},
+		{function: "interval", err: "too many timers"},
+	}
+
+	withEngines(t, func(t *testing.T) {
+		vm, err := Compile(source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer vm.Dispose()
+		vm.SetTimeout(time.Second)
+
+		for _, tt := range tests {
+			got, err := vm.Invoke(tt.function, "10")
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("%s: Invoke() = %#v, %v, want error containing %q", tt.function, got, err, tt.err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s: Invoke() error = %v", tt.function, err)
+			} else if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: Invoke() = %#v, want %#v", tt.function, got, tt.want)
+			}
+		}
+	})
+}
+
+func TestInvokeTimeout(t *testing.T) {
+	source := `js_exports.loop = function () { for (;;) {} };
+js_exports.ok = function () { return "ok"; };`
+
+	withEngines(t, func(t *testing.T) {
+		vm, err := Compile(source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer vm.Dispose()
+		vm.SetTimeout(50 * time.Millisecond)
+
+		start := time.Now()
+		_, err = vm.Invoke("loop")
+		if !errors.Is(err, ErrTimeout) {
+			t.Fatalf("Invoke(loop) error = %v, want ErrTimeout", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("Invoke(loop) took %s", elapsed)
+		}
+
+		// The script can still be used after it was interrupted.
+		got, err := vm.Invoke("ok")
+		if err != nil || got != "ok" {
+			t.Errorf("Invoke(ok) after a timeout = %v, %v", got, err)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package js
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// nodeSource defines the Node-compatible globals: process, timers,
+// TextEncoder/TextDecoder, Buffer, URL/URLSearchParams and require for
+// those built-in modules.
+//
+//go:embed node.js
+var nodeSource string
+
+// nodeFuncs returns the host functions used by nodeSource.
+func nodeFuncs() map[string]Func {
+	return map[string]Func{
+		"__host_env":      hostEnv,
+		"__host_parseURL": hostParseURL,
+	}
+}
+
+// Env returns the variables of the environment that scripts see as
+// process.env.
+func Env() map[string]string {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if i := strings.Index(kv, "="); i > 0 && exposedEnv(kv[:i]) {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	return env
+}
+
+// hostEnv returns Env as a JSON object.
+func hostEnv(args ...string) (string, error) {
+	data, err := json.Marshal(Env())
+	return string(data), err
+}
+
+// exposedEnv reports whether a variable is visible to scripts. Only NODE_ENV
+// and the CLI's own WAPC_ variables are, except for the token, so that
+// visitors cannot read credentials from the host environment.
+func exposedEnv(name string) bool {
+	if name == "NODE_ENV" {
+		return true
+	}
+	return strings.HasPrefix(name, "WAPC_") && name != "WAPC_TOKEN"
+}
+
+// urlParts are the properties of a parsed URL object.
+type urlParts struct {
+	Href     string `json:"href"`
+	Origin   string `json:"origin"`
+	Protocol string `json:"protocol"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Host     string `json:"host"`
+	Hostname string `json:"hostname"`
+	Port     string `json:"port"`
+	Pathname string `json:"pathname"`
+	Search   string `json:"search"`
+	Hash     string `json:"hash"`
+}
+
+// hostParseURL parses a URL, optionally relative to a base URL, and returns
+// its parts as JSON.
+func hostParseURL(args ...string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("invalid arguments")
+	}
+
+	u, err := url.Parse(args[0])
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q", args[0])
+	}
+	if base := args[1]; base != "" {
+		b, err := url.Parse(base)
+		if err != nil || !b.IsAbs() {
+			return "", fmt.Errorf("invalid base URL %q", base)
+		}
+		u = b.ResolveReference(u)
+	}
+	if !u.IsAbs() {
+		return "", fmt.Errorf("invalid URL %q", args[0])
+	}
+	if u.Host != "" && u.Path == "" {
+		u.Path = "/"
+	}
+
+	parts := urlParts{
+		Protocol: u.Scheme + ":",
+		Host:     u.Host,
+		Hostname: u.Hostname(),
+		Port:     u.Port(),
+		Pathname: u.EscapedPath(),
+		Origin:   "null",
+	}
+	if u.Opaque != "" {
+		parts.Pathname = u.Opaque
+	}
+	if u.User != nil {
+		parts.Username = u.User.Username()
+		parts.Password, _ = u.User.Password()
+	}
+	if u.RawQuery != "" {
+		parts.Search = "?" + u.RawQuery
+	}
+	if u.Fragment != "" {
+		parts.Hash = "#" + u.EscapedFragment()
+	}
+	switch u.Scheme {
+	case "http", "https", "ws", "wss", "ftp":
+		parts.Origin = u.Scheme + "://" + u.Host
+	}
+	parts.Href = u.String()
+
+	data, err := json.Marshal(parts)
+	return string(data), err
+}
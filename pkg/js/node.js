@@ -0,0 +1,428 @@
+// Node-compatible globals for bundled npm libraries. Only a curated subset of
+// Node is provided; host-backed pieces call into Go through the __host_*
+// functions registered by Compile.
+(function (global) {
+  "use strict";
+
+  function hostResult(result) {
+    if (result.startsWith("error: ")) {
+      throw new TypeError(result.substring(7));
+    }
+    return result;
+  }
+
+  // process
+
+  const process = {
+    env: JSON.parse(__host_env()),
+    argv: [],
+    platform: "wapc",
+    version: "",
+    versions: {},
+    cwd() {
+      return "/";
+    },
+    nextTick(callback, ...args) {
+      queueMicrotask(() => callback(...args));
+    },
+  };
+
+  // Timers run on a virtual clock after the invoked function returns.
+
+  let timerSeq = 0;
+  let timerNow = 0;
+  const timers = new Map();
+
+  function addTimer(callback, delay, args, repeat) {
+    if (typeof callback !== "function") {
+      throw new TypeError("callback must be a function");
+    }
+    delay = Math.max(0, Number(delay) || 0);
+    const id = ++timerSeq;
+    timers.set(id, { id, callback, args, delay, repeat, at: timerNow + delay });
+    return id;
+  }
+
+  function clearTimer(id) {
+    timers.delete(id);
+  }
+
+  function queueMicrotask(callback) {
+    Promise.resolve().then(callback);
+  }
+
+  // runTimer runs the next due timer and reports whether there was one. The
+  // host calls it until no timers are left, running microtasks in between.
+  function runTimer() {
+    let next;
+    for (const timer of timers.values()) {
+      if (!next || timer.at < next.at) {
+        next = timer;
+      }
+    }
+    if (!next) {
+      return false;
+    }
+    timerNow = next.at;
+    if (next.repeat) {
+      next.at = timerNow + Math.max(1, next.delay);
+    } else {
+      timers.delete(next.id);
+    }
+    next.callback(...next.args);
+    return true;
+  }
+
+  // TextEncoder and TextDecoder (UTF-8 only)
+
+  class TextEncoder {
+    get encoding() {
+      return "utf-8";
+    }
+
+    encode(input = "") {
+      const binary = unescape(encodeURIComponent(String(input)));
+      const bytes = new Uint8Array(binary.length);
+      for (let i = 0; i < binary.length; i++) {
+        bytes[i] = binary.charCodeAt(i);
+      }
+      return bytes;
+    }
+  }
+
+  class TextDecoder {
+    constructor(label = "utf-8") {
+      label = String(label).toLowerCase();
+      if (label !== "utf-8" && label !== "utf8") {
+        throw new RangeError(`unsupported encoding ${label}`);
+      }
+    }
+
+    get encoding() {
+      return "utf-8";
+    }
+
+    decode(input) {
+      if (input === undefined) {
+        return "";
+      }
+      const bytes = toBytes(input);
+      let binary = "";
+      for (let i = 0; i < bytes.length; i++) {
+        binary += String.fromCharCode(bytes[i]);
+      }
+      try {
+        return decodeURIComponent(escape(binary));
+      } catch (e) {
+        throw new TypeError("the encoded data was not valid utf-8");
+      }
+    }
+  }
+
+  function toBytes(input) {
+    if (input instanceof Uint8Array) {
+      return input;
+    }
+    if (input instanceof ArrayBuffer) {
+      return new Uint8Array(input);
+    }
+    if (ArrayBuffer.isView(input)) {
+      return new Uint8Array(input.buffer, input.byteOffset, input.byteLength);
+    }
+    throw new TypeError("expected an ArrayBuffer or ArrayBufferView");
+  }
+
+  // Buffer
+
+  const base64Chars =
+    "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/";
+
+  function encodeBase64(bytes) {
+    let out = "";
+    for (let i = 0; i < bytes.length; i += 3) {
+      const n = (bytes[i] << 16) | ((bytes[i + 1] || 0) << 8) | (bytes[i + 2] || 0);
+      out += base64Chars[(n >> 18) & 63] + base64Chars[(n >> 12) & 63];
+      out += i + 1 < bytes.length ? base64Chars[(n >> 6) & 63] : "=";
+      out += i + 2 < bytes.length ? base64Chars[n & 63] : "=";
+    }
+    return out;
+  }
+
+  function decodeBase64(input) {
+    input = input.replace(/-/g, "+").replace(/_/g, "/").replace(/[^A-Za-z0-9+/]/g, "");
+    const bytes = [];
+    let bits = 0;
+    let value = 0;
+    for (const c of input) {
+      value = (value << 6) | base64Chars.indexOf(c);
+      bits += 6;
+      if (bits >= 8) {
+        bits -= 8;
+        bytes.push((value >> bits) & 255);
+      }
+    }
+    return bytes;
+  }
+
+  class Buffer extends Uint8Array {
+    static from(value, encodingOrOffset, length) {
+      if (typeof value === "string") {
+        return Buffer.fromString(value, encodingOrOffset);
+      }
+      if (value instanceof ArrayBuffer) {
+        return new Buffer(value, encodingOrOffset || 0, length);
+      }
+      if (ArrayBuffer.isView(value) || Array.isArray(value)) {
+        const buffer = new Buffer(value.length);
+        buffer.set(value);
+        return buffer;
+      }
+      throw new TypeError("unsupported argument to Buffer.from");
+    }
+
+    static fromString(value, encoding = "utf8") {
+      let bytes;
+      switch (String(encoding).toLowerCase()) {
+        case "utf8":
+        case "utf-8":
+          bytes = new TextEncoder().encode(value);
+          break;
+        case "base64":
+        case "base64url":
+          bytes = decodeBase64(value);
+          break;
+        case "hex":
+          bytes = [];
+          for (let i = 0; i + 1 < value.length; i += 2) {
+            bytes.push(parseInt(value.substr(i, 2), 16));
+          }
+          break;
+        case "ascii":
+        case "latin1":
+        case "binary":
+          bytes = Array.from(value, (c) => c.charCodeAt(0) & 255);
+          break;
+        default:
+          throw new TypeError(`unknown encoding ${encoding}`);
+      }
+      return Buffer.from(bytes);
+    }
+
+    static alloc(size, fill = 0) {
+      return new Buffer(size).fill(fill);
+    }
+
+    static isBuffer(value) {
+      return value instanceof Buffer;
+    }
+
+    static byteLength(value, encoding) {
+      return typeof value === "string"
+        ? Buffer.fromString(value, encoding).length
+        : value.byteLength;
+    }
+
+    static concat(list, totalLength) {
+      if (totalLength === undefined) {
+        totalLength = list.reduce((n, b) => n + b.length, 0);
+      }
+      const buffer = Buffer.alloc(totalLength);
+      let offset = 0;
+      for (const b of list) {
+        buffer.set(b.subarray(0, totalLength - offset), offset);
+        offset += b.length;
+        if (offset >= totalLength) {
+          break;
+        }
+      }
+      return buffer;
+    }
+
+    toString(encoding = "utf8", start = 0, end = this.length) {
+      const bytes = this.subarray(start, end);
+      switch (String(encoding).toLowerCase()) {
+        case "utf8":
+        case "utf-8":
+          return new TextDecoder().decode(bytes);
+        case "base64":
+          return encodeBase64(bytes);
+        case "base64url":
+          return encodeBase64(bytes).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+        case "hex":
+          return Array.from(bytes, (b) => b.toString(16).padStart(2, "0")).join("");
+        case "ascii":
+        case "latin1":
+        case "binary":
+          return String.fromCharCode(...bytes);
+        default:
+          throw new TypeError(`unknown encoding ${encoding}`);
+      }
+    }
+
+    equals(other) {
+      return this.length === other.length && this.every((b, i) => b === other[i]);
+    }
+
+    toJSON() {
+      return { type: "Buffer", data: Array.from(this) };
+    }
+  }
+
+  // URL and URLSearchParams. URLs are parsed by Go's net/url.
+
+  class URLSearchParams {
+    constructor(init = "") {
+      this._list = [];
+      if (typeof init === "string") {
+        for (const pair of init.replace(/^\?/, "").split("&")) {
+          if (pair === "") {
+            continue;
+          }
+          const i = pair.indexOf("=");
+          const name = i < 0 ? pair : pair.substring(0, i);
+          const value = i < 0 ? "" : pair.substring(i + 1);
+          this._list.push([decodeParam(name), decodeParam(value)]);
+        }
+      } else if (init && typeof init[Symbol.iterator] === "function") {
+        for (const [name, value] of init) {
+          this._list.push([String(name), String(value)]);
+        }
+      } else if (init && typeof init === "object") {
+        for (const name of Object.keys(init)) {
+          this._list.push([name, String(init[name])]);
+        }
+      }
+    }
+
+    append(name, value) {
+      this._list.push([String(name), String(value)]);
+    }
+
+    delete(name) {
+      this._list = this._list.filter(([n]) => n !== name);
+    }
+
+    get(name) {
+      const entry = this._list.find(([n]) => n === name);
+      return entry ? entry[1] : null;
+    }
+
+    getAll(name) {
+      return this._list.filter(([n]) => n === name).map(([, v]) => v);
+    }
+
+    has(name) {
+      return this._list.some(([n]) => n === name);
+    }
+
+    set(name, value) {
+      const i = this._list.findIndex(([n]) => n === name);
+      if (i < 0) {
+        this.append(name, value);
+        return;
+      }
+      this._list[i] = [String(name), String(value)];
+      this._list = this._list.filter(([n], j) => j <= i || n !== name);
+    }
+
+    forEach(callback, thisArg) {
+      for (const [name, value] of this._list) {
+        callback.call(thisArg, value, name, this);
+      }
+    }
+
+    keys() {
+      return this._list.map(([n]) => n)[Symbol.iterator]();
+    }
+
+    values() {
+      return this._list.map(([, v]) => v)[Symbol.iterator]();
+    }
+
+    entries() {
+      return this._list.map(([n, v]) => [n, v])[Symbol.iterator]();
+    }
+
+    [Symbol.iterator]() {
+      return this.entries();
+    }
+
+    toString() {
+      return this._list
+        .map(([n, v]) => encodeParam(n) + "=" + encodeParam(v))
+        .join("&");
+    }
+  }
+
+  function decodeParam(value) {
+    return decodeURIComponent(value.replace(/\+/g, " "));
+  }
+
+  function encodeParam(value) {
+    return encodeURIComponent(value).replace(/%20/g, "+");
+  }
+
+  class URL {
+    constructor(url, base) {
+      const parts = JSON.parse(
+        hostResult(__host_parseURL(String(url), base === undefined ? "" : String(base)))
+      );
+      Object.assign(this, parts);
+      this.searchParams = new URLSearchParams(parts.search);
+    }
+
+    toString() {
+      return this.href;
+    }
+
+    toJSON() {
+      return this.href;
+    }
+  }
+
+  // require
+
+  const builtins = {
+    buffer: { Buffer },
+    process: process,
+    url: { URL, URLSearchParams },
+    util: { TextEncoder, TextDecoder },
+  };
+
+  function require(name) {
+    name = String(name).replace(/^node:/, "");
+    if (Object.prototype.hasOwnProperty.call(builtins, name)) {
+      return builtins[name];
+    }
+    const err = new Error(`Cannot find module '${name}'`);
+    err.code = "MODULE_NOT_FOUND";
+    throw err;
+  }
+
+  Object.assign(global, {
+    global,
+    process,
+    setTimeout: (callback, delay, ...args) => addTimer(callback, delay, args, false),
+    clearTimeout: clearTimer,
+    setInterval: (callback, delay, ...args) => addTimer(callback, delay, args, true),
+    clearInterval: clearTimer,
+    setImmediate: (callback, ...args) => addTimer(callback, 0, args, false),
+    clearImmediate: clearTimer,
+    queueMicrotask,
+    TextEncoder,
+    TextDecoder,
+    Buffer,
+    URL,
+    URLSearchParams,
+    require,
+    __runTimer: runTimer,
+  });
+  builtins.timers = {
+    setTimeout: global.setTimeout,
+    clearTimeout: global.clearTimeout,
+    setInterval: global.setInterval,
+    clearInterval: global.clearInterval,
+    setImmediate: global.setImmediate,
+    clearImmediate: global.clearImmediate,
+  };
+})(globalThis);
@@ -0,0 +1,304 @@
+//go:build cgo && !nov8
+// +build cgo,!nov8
+
+package js
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"rogchap.com/v8go"
+)
+
+func init() {
+	engines["v8"] = compileV8
+}
+
+// v8JS runs scripts in a V8 isolate.
+type v8JS struct {
+	iso     *v8go.Isolate
+	ctx     *v8go.Context
+	timeout time.Duration
+}
+
+func compileV8(source string, globals map[string]Func) (JS, error) {
+	iso, err := v8go.NewIsolate()
+	if err != nil {
+		return nil, err
+	}
+	global, err := v8go.NewObjectTemplate(iso)
+	if err != nil {
+		return nil, err
+	}
+	for name, fn := range globals {
+		funcTemp, err := v8go.NewFunctionTemplate(iso, v8Callback(fn))
+		if err != nil {
+			return nil, err
+		}
+		global.Set(name, funcTemp)
+	}
+	ctx, err := v8go.NewContext(iso, global)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range preludes() {
+		if _, err = ctx.RunScript(s.source, s.name); err != nil {
+			return nil, v8Error(err)
+		}
+	}
+
+	js := v8JS{
+		iso:     iso,
+		ctx:     ctx,
+		timeout: DefaultTimeout,
+	}
+	_, err = js.run(func() (*v8go.Value, error) {
+		return ctx.RunScript(source, "bundle.js")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &js, nil
+}
+
+// v8Callback adapts a host function to V8.
+func v8Callback(fn Func) v8go.FunctionCallback {
+	return func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+		iso, err := info.Context().Isolate()
+		if err != nil {
+			return nil
+		}
+		args := make([]string, len(info.Args()))
+		for i, arg := range info.Args() {
+			args[i] = arg.String()
+		}
+		value, _ := v8go.NewValue(iso, fn.call(args))
+		return value
+	}
+}
+
+// v8Error converts exceptions thrown by scripts to *Error.
+func v8Error(err error) error {
+	var jserr *v8go.JSError
+	if errors.As(err, &jserr) {
+		return &Error{Message: jserr.Message, StackTrace: jserr.StackTrace}
+	}
+	return err
+}
+
+func (js *v8JS) Dispose() {
+	js.ctx.Close()
+	js.iso.Dispose()
+}
+
+func (js *v8JS) SetTimeout(timeout time.Duration) {
+	js.timeout = timeout
+}
+
+func (js *v8JS) Invoke(function string, args ...interface{}) (interface{}, error) {
+	res, err := js.invoke(function, args...)
+	if err != nil {
+		return nil, err
+	}
+	return js.toGo(res)
+}
+
+func (js *v8JS) InvokeInto(out interface{}, function string, args ...interface{}) error {
+	res, err := js.invoke(function, args...)
+	if err != nil {
+		return err
+	}
+	data, err := js.toJSON(res)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (js *v8JS) invoke(function string, args ...interface{}) (*v8go.Value, error) {
+	fn, err := js.export(function)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]v8go.Valuer, len(args))
+	for i, arg := range args {
+		if values[i], err = js.convertInterface(arg); err != nil {
+			return nil, err
+		}
+	}
+
+	// Timers and promises started by the function are settled once it
+	// returns.
+	return js.run(func() (*v8go.Value, error) {
+		res, err := fn.Call(values...)
+		if err != nil {
+			return nil, err
+		}
+		return js.settle(res)
+	})
+}
+
+// export returns the exported function with the given name.
+func (js *v8JS) export(name string) (*v8go.Function, error) {
+	value, err := js.ctx.Global().Get("js_exports")
+	if err != nil {
+		return nil, err
+	}
+	exports, err := value.AsObject()
+	if err != nil {
+		return nil, err
+	}
+	if !exports.Has(name) {
+		return nil, &NoSuchExportError{Name: name}
+	}
+	if value, err = exports.Get(name); err != nil {
+		return nil, err
+	}
+	if !value.IsFunction() {
+		return nil, &NoSuchExportError{Name: name}
+	}
+
+	return value.AsFunction()
+}
+
+func (js *v8JS) convertInterface(value interface{}) (*v8go.Value, error) {
+	jsonBytes, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return v8go.JSONParse(js.ctx, string(jsonBytes))
+}
+
+// run runs fn and terminates the script if it is still running when the
+// timeout elapses.
+func (js *v8JS) run(fn func() (*v8go.Value, error)) (*v8go.Value, error) {
+	var value *v8go.Value
+	err := withTimeout(js.timeout, js.iso.TerminateExecution, func() (err error) {
+		value, err = fn()
+		return v8Error(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// settle runs pending microtasks and timers until none are left. If value is
+// a promise, its result is returned once it is fulfilled and its rejection
+// reason is returned as an error.
+func (js *v8JS) settle(value *v8go.Value) (*v8go.Value, error) {
+	runTimer, err := js.global("__runTimer")
+	if err != nil {
+		return nil, err
+	}
+	err = runTimers(func() (bool, error) {
+		js.ctx.PerformMicrotaskCheckpoint()
+		ran, err := runTimer.Call()
+		if err != nil {
+			return false, err
+		}
+		return ran.Boolean(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !value.IsPromise() {
+		return value, nil
+	}
+	promise, err := value.AsPromise()
+	if err != nil {
+		return nil, err
+	}
+	switch promise.State() {
+	case v8go.Fulfilled:
+		return promise.Result(), nil
+	case v8go.Rejected:
+		return nil, js.rejection(promise.Result())
+	}
+
+	return nil, errors.New("promise never settled")
+}
+
+// rejection converts a promise's rejection reason to an error. Reasons other
+// than errors are described as JSON where possible.
+func (js *v8JS) rejection(reason *v8go.Value) error {
+	jserr := Error{
+		Message: reason.DetailString(),
+	}
+	switch {
+	case reason.IsNativeError():
+		jserr.Message = reason.String()
+		if object, err := reason.AsObject(); err == nil {
+			if stack, err := object.Get("stack"); err == nil && stack.IsString() {
+				jserr.StackTrace = stack.String()
+			}
+		}
+	case reason.IsObject():
+		if data, err := js.toJSON(reason); err == nil {
+			jserr.Message = string(data)
+		}
+	}
+
+	return &jserr
+}
+
+// toGo converts a value returned by a script as described by convertSource.
+func (js *v8JS) toGo(value *v8go.Value) (interface{}, error) {
+	switch {
+	case value.IsNullOrUndefined():
+		return nil, nil
+	case value.IsString():
+		return value.String(), nil
+	case value.IsBoolean():
+		return value.Boolean(), nil
+	case value.IsInt32():
+		return value.Int32(), nil
+	case value.IsNumber():
+		return value.Number(), nil
+	case value.IsBigInt():
+		// BigInts are strings like those inside of objects.
+		return value.String(), nil
+	case value.IsFunction(), value.IsSymbol():
+		return nil, fmt.Errorf("cannot convert %s to a Go value", value.DetailString())
+	}
+
+	data, err := js.toJSON(value)
+	if err != nil {
+		return nil, err
+	}
+	return fromJSON(data)
+}
+
+// toJSON serializes a value with JSON.stringify.
+func (js *v8JS) toJSON(value *v8go.Value) ([]byte, error) {
+	fn, err := js.global("js_toJSON")
+	if err != nil {
+		return nil, err
+	}
+	result, err := fn.Call(value)
+	if err != nil {
+		return nil, v8Error(err)
+	}
+	if result.IsUndefined() {
+		return nil, fmt.Errorf("cannot convert %s to JSON", value.DetailString())
+	}
+
+	return []byte(result.String()), nil
+}
+
+// global returns the global function with the given name.
+func (js *v8JS) global(name string) (*v8go.Function, error) {
+	value, err := js.ctx.Global().Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if !value.IsFunction() {
+		return nil, errors.New(name + " is not a function")
+	}
+	return value.AsFunction()
+}